@@ -0,0 +1,129 @@
+// Package auth provides the user authentication layer
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrUnknownUser is returned by an authenticator that doesn't know the user, the next authenticator of the chain
+// is then tried
+var ErrUnknownUser = errors.New("unknown user")
+
+// ErrNoAccess is returned when an authenticator accepted a user without providing any access
+var ErrNoAccess = errors.New("no access provided")
+
+// RestrictedFieldError is returned when an access received from a database or a webhook sets a field reserved to the
+// config file
+type RestrictedFieldError struct {
+	error
+	Field string
+}
+
+func (err RestrictedFieldError) Error() string {
+	return fmt.Sprintf("%s can only be set in the config file", err.Field)
+}
+
+// CheckExternalAccess rejects the accesses received from a database or a webhook that set on_upload, whose commands
+// are run by the server, or totp_secret, which is only checked for the accesses of the config file
+func CheckExternalAccess(access *confpar.Access) error {
+	switch {
+	case len(access.OnUpload) > 0:
+		return RestrictedFieldError{Field: "on_upload"}
+	case access.TOTPSecret != "":
+		return RestrictedFieldError{Field: "totp_secret"}
+	default:
+		return nil
+	}
+}
+
+// Authenticator checks the credentials of a user and provides the associated access.
+// Authenticators holding resources, like database connections, can also implement io.Closer.
+type Authenticator interface {
+	// Authenticate returns the access of the user, or an error if the credentials are rejected
+	Authenticate(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error)
+}
+
+// Func allows to use a simple function as an Authenticator
+type Func func(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error)
+
+// Authenticate calls the underlying function
+func (f Func) Authenticate(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	return f(cc, user, pass)
+}
+
+// Link is a named authenticator of a chain
+type Link struct {
+	Name string
+	Authenticator
+}
+
+// Chain tries its authenticators in order. Each of them can accept the user, reject it with an error,
+// or pass with ErrUnknownUser to let the next one decide.
+type Chain struct {
+	links  []*Link
+	logger log.Logger
+}
+
+// NewChain creates an authenticators chain
+func NewChain(logger log.Logger, links ...*Link) *Chain {
+	return &Chain{
+		links:  links,
+		logger: logger,
+	}
+}
+
+// Authenticate returns the access provided by the first authenticator accepting or rejecting the user
+func (c *Chain) Authenticate(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	access, _, err := c.Resolve(cc, user, pass)
+
+	return access, err
+}
+
+// Resolve works like Authenticate but also returns the name of the authenticator that made the decision,
+// or an empty string if none of them knew the user
+func (c *Chain) Resolve(cc serverlib.ClientContext, user, pass string) (*confpar.Access, string, error) {
+	for _, link := range c.links {
+		access, err := link.Authenticate(cc, user, pass)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+
+		if err == nil && access == nil {
+			err = ErrNoAccess
+		}
+
+		if err != nil {
+			c.logger.Debug("User rejected", "user", user, "authenticator", link.Name, "err", err)
+
+			return nil, link.Name, err
+		}
+
+		c.logger.Debug("User accepted", "user", user, "authenticator", link.Name)
+
+		return access, link.Name, nil
+	}
+
+	return nil, "", ErrUnknownUser
+}
+
+// Close closes the authenticators of the chain implementing io.Closer
+func (c *Chain) Close() error {
+	var errs []error
+
+	for _, link := range c.links {
+		if closer, ok := link.Authenticator.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", link.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+var errRejected = errors.New("rejected")
+
+func staticAuth(accepted, rejected string) Func {
+	return func(_ serverlib.ClientContext, user, _ string) (*confpar.Access, error) {
+		switch user {
+		case accepted:
+			return &confpar.Access{User: user}, nil
+		case rejected:
+			return nil, errRejected
+		default:
+			return nil, ErrUnknownUser
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := NewChain(
+		lognoop.NewNoOpLogger(),
+		&Link{Name: "first", Authenticator: staticAuth("admin", "bob")},
+		&Link{Name: "second", Authenticator: staticAuth("bob", "")},
+	)
+
+	if access, err := chain.Authenticate(nil, "admin", ""); err != nil || access.User != "admin" {
+		t.Error("First link should accept", err)
+	}
+
+	if _, err := chain.Authenticate(nil, "bob", ""); !errors.Is(err, errRejected) {
+		t.Error("First link should reject", err)
+	}
+
+	if _, err := chain.Authenticate(nil, "alice", ""); !errors.Is(err, ErrUnknownUser) {
+		t.Error("No link should accept", err)
+	}
+}
+
+type closer struct {
+	Func
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+
+	return nil
+}
+
+func TestChainClose(t *testing.T) {
+	db := &closer{Func: staticAuth("", "")}
+	chain := NewChain(
+		lognoop.NewNoOpLogger(),
+		&Link{Name: "static", Authenticator: staticAuth("admin", "")},
+		&Link{Name: "sql", Authenticator: db},
+	)
+
+	if err := chain.Close(); err != nil || !db.closed {
+		t.Error("The sql link should be closed", err)
+	}
+}
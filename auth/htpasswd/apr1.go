@@ -0,0 +1,93 @@
+package htpasswd
+
+import (
+	"crypto/md5" // nolint: gosec // Required by the apr1 format
+	"crypto/subtle"
+	"strings"
+)
+
+// apr1Prefix identifies the Apache variant of md5crypt, the default format of the htpasswd command
+const apr1Prefix = "$apr1$"
+
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// matchAPR1 checks a password against an apr1 hash
+func matchAPR1(hash, pass string) bool {
+	salt, _, found := strings.Cut(strings.TrimPrefix(hash, apr1Prefix), "$")
+	if !found {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(apr1(pass, salt)), []byte(hash)) == 1
+}
+
+// apr1 hashes a password like md5crypt, with the "$apr1$" magic string
+func apr1(pass, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	password := []byte(pass)
+
+	alternate := md5.Sum([]byte(pass + salt + pass)) // nolint: gosec
+
+	digest := md5.New() // nolint: gosec
+	digest.Write([]byte(pass + apr1Prefix + salt))
+
+	for i := len(password); i > 0; i -= md5.Size {
+		digest.Write(alternate[:min(i, md5.Size)])
+	}
+
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write(password[:1])
+		}
+	}
+
+	final := digest.Sum(nil)
+
+	for i := range 1000 {
+		round := md5.New() // nolint: gosec
+
+		if i&1 == 1 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+
+		if i%7 != 0 {
+			round.Write(password)
+		}
+
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+
+		final = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+
+	encode := func(value uint, chars int) {
+		for range chars {
+			encoded.WriteByte(apr1Alphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[group[0]])<<16|uint(final[group[1]])<<8|uint(final[group[2]]), 4)
+	}
+
+	encode(uint(final[11]), 2)
+
+	return apr1Prefix + salt + "$" + encoded.String()
+}
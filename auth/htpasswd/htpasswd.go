@@ -0,0 +1,159 @@
+// Package htpasswd provides an htpasswd file authentication layer
+package htpasswd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+	"github.com/go-crypt/crypt"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrMissingFile is returned when the file property isn't specified
+var ErrMissingFile = errors.New("htpasswd file must be specified")
+
+// ErrMissingAccess is returned when the access template isn't specified
+var ErrMissingAccess = errors.New("htpasswd access must be specified")
+
+// ErrInvalidPassword is returned when the password doesn't match
+var ErrInvalidPassword = errors.New("invalid password")
+
+// Authenticator authenticates users against an htpasswd file. The file is reloaded whenever it changes.
+type Authenticator struct {
+	file    string
+	access  *confpar.Access
+	logger  log.Logger
+	decoder *crypt.Decoder
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]string
+}
+
+// New creates an htpasswd authenticator, all its users share the same access template
+func New(file string, access *confpar.Access, logger log.Logger) (*Authenticator, error) {
+	if file == "" {
+		return nil, ErrMissingFile
+	}
+
+	if access == nil {
+		return nil, ErrMissingAccess
+	}
+
+	decoder, err := crypt.NewDecoderAll()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		file:    file,
+		access:  access,
+		logger:  logger,
+		decoder: decoder,
+	}
+
+	if _, err := a.getHash(""); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Authenticate checks the password against the hash of the file
+func (a *Authenticator) Authenticate(_ serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	hash, err := a.getHash(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash == "" {
+		return nil, auth.ErrUnknownUser
+	}
+
+	if strings.HasPrefix(hash, apr1Prefix) {
+		if !matchAPR1(hash, pass) {
+			return nil, ErrInvalidPassword
+		}
+
+		return a.access.Instantiate(user, nil), nil
+	}
+
+	digest, err := a.decoder.Decode(hash)
+	if err != nil {
+		a.logger.Warn("Unsupported password hash", "user", user, "err", err)
+
+		return nil, ErrInvalidPassword
+	}
+
+	ok, err := digest.MatchAdvanced(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidPassword
+	}
+
+	return a.access.Instantiate(user, nil), nil
+}
+
+// getHash returns the hash of a user, after reloading the file if it changed
+func (a *Authenticator) getHash(user string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return "", fmt.Errorf("could not stat htpasswd file: %w", err)
+	}
+
+	if a.users == nil || !info.ModTime().Equal(a.modTime) {
+		users, err := readFile(a.file)
+		if err != nil {
+			return "", err
+		}
+
+		a.logger.Debug("Loaded htpasswd file", "file", a.file, "nbUsers", len(users))
+		a.users = users
+		a.modTime = info.ModTime()
+	}
+
+	return a.users[user], nil
+}
+
+func readFile(fileName string) (map[string]string, error) {
+	file, err := os.Open(fileName) // nolint: gosec
+	if err != nil {
+		return nil, fmt.Errorf("could not open htpasswd file: %w", err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if user, hash, found := strings.Cut(line, ":"); found && user != "" {
+			users[user] = hash
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read htpasswd file: %w", err)
+	}
+
+	return users, nil
+}
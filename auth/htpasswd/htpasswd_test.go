@@ -0,0 +1,63 @@
+package htpasswd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestAuthenticate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+
+	// The "password" of both users is hashed with "htpasswd -B" and "htpasswd -m"
+	err := os.WriteFile(file, []byte(`# Users
+alice:$2a$05$mFhqiI7l0X1tDrIRmOvUeuGiHMD8nqJCj0aJnZuISkNNoEJhIhaci
+bob:$apr1$abcdefgh$FBwExRW4dCc8aL.OvjpIE1
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := New(file, &confpar.Access{Fs: "os", Params: map[string]string{"basePath": "/srv/{user}"}},
+		lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := auth.NewChain(
+		lognoop.NewNoOpLogger(),
+		&auth.Link{Name: "htpasswd", Authenticator: authenticator},
+		&auth.Link{Name: "static", Authenticator: auth.Func(
+			func(_ serverlib.ClientContext, user, _ string) (*confpar.Access, error) {
+				return &confpar.Access{User: user, Fs: "static"}, nil
+			},
+		)},
+	)
+
+	for _, user := range []string{"alice", "bob"} {
+		access, errAuth := chain.Authenticate(nil, user, "password")
+		if errAuth != nil || access.User != user || access.Params["basePath"] != "/srv/"+user {
+			t.Fatal("Unexpected result:", access, errAuth)
+		}
+
+		if _, errAuth = chain.Authenticate(nil, user, "wrong"); !errors.Is(errAuth, ErrInvalidPassword) {
+			t.Fatal("Unexpected error:", errAuth)
+		}
+	}
+
+	// The unknown users are left to the next authenticator
+	if _, err = authenticator.Authenticate(nil, "carol", "password"); !errors.Is(err, auth.ErrUnknownUser) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if access, errAuth := chain.Authenticate(nil, "carol", "password"); errAuth != nil || access.Fs != "static" {
+		t.Fatal("Unexpected result:", access, errAuth)
+	}
+}
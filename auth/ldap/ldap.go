@@ -0,0 +1,244 @@
+// Package ldap provides an LDAP / Active Directory authentication layer
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrMissingURL is returned when the url property isn't specified
+var ErrMissingURL = errors.New("ldap url must be specified")
+
+// ErrMissingBaseDN is returned when the base_dn property isn't specified
+var ErrMissingBaseDN = errors.New("ldap base_dn must be specified")
+
+// ErrInvalidCredentials is returned when the user isn't unique or its password is wrong
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrNoMatchingGroup is returned when none of the user's groups is mapped to an access
+var ErrNoMatchingGroup = errors.New("no access for the user's groups")
+
+const (
+	defaultUserFilter     = "(uid={user})"
+	defaultGroupAttribute = "memberOf"
+	defaultTimeout        = 10 * time.Second
+	anyGroup              = "*"
+)
+
+// ldapConn is the part of an LDAP connection used by the authenticator
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Authenticator authenticates users against an LDAP directory
+type Authenticator struct {
+	conf   *confpar.LDAP
+	logger log.Logger
+	dial   func() (ldapConn, error)
+}
+
+// New creates an LDAP authenticator from its config
+func New(conf *confpar.LDAP, logger log.Logger) (*Authenticator, error) {
+	if conf.URL == "" {
+		return nil, ErrMissingURL
+	}
+
+	if conf.BaseDN == "" {
+		return nil, ErrMissingBaseDN
+	}
+
+	a := &Authenticator{
+		conf:   conf,
+		logger: logger,
+	}
+	a.dial = a.dialURL
+
+	return a, nil
+}
+
+// Authenticate binds as the user and returns the access mapped to its groups
+func (a *Authenticator) Authenticate(_ serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	// An empty password would lead to an unauthenticated bind, which always succeeds
+	if user == "" || pass == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errClose := conn.Close(); errClose != nil {
+			a.logger.Debug("Problem closing ldap connection", "err", errClose)
+		}
+	}()
+
+	entry, err := a.findUser(conn, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, pass); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, fmt.Errorf("could not bind as user: %w", err)
+	}
+
+	group := a.matchGroup(entry.GetAttributeValues(a.groupAttribute()))
+	if group == nil {
+		a.logger.Warn("No access for the user's groups", "user", user, "dn", entry.DN)
+
+		return nil, ErrNoMatchingGroup
+	}
+
+	access := buildAccess(group.Access, user, entry)
+	access.Template = group.Group
+
+	return access, nil
+}
+
+func (a *Authenticator) timeout() time.Duration {
+	if a.conf.Timeout > 0 {
+		return a.conf.Timeout
+	}
+
+	return defaultTimeout
+}
+
+func (a *Authenticator) groupAttribute() string {
+	if a.conf.GroupAttribute != "" {
+		return a.conf.GroupAttribute
+	}
+
+	return defaultGroupAttribute
+}
+
+func (a *Authenticator) dialURL() (ldapConn, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: a.conf.InsecureSkipVerify, // nolint: gosec
+	}
+
+	conn, err := ldap.DialURL(
+		a.conf.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.timeout()}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to ldap server: %w", err)
+	}
+
+	conn.SetTimeout(a.timeout())
+
+	if a.conf.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			_ = conn.Close()
+
+			return nil, fmt.Errorf("could not start tls: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (a *Authenticator) findUser(conn ldapConn, user string) (*ldap.Entry, error) {
+	if a.conf.BindDN != "" {
+		if err := conn.Bind(a.conf.BindDN, a.conf.BindPassword); err != nil {
+			return nil, fmt.Errorf("could not bind as service account: %w", err)
+		}
+	}
+
+	filter := a.conf.UserFilter
+	if filter == "" {
+		filter = defaultUserFilter
+	}
+
+	search := ldap.NewSearchRequest(
+		a.conf.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // We only need to know if there's more than one result
+		int(a.timeout().Seconds()),
+		false,
+		strings.ReplaceAll(filter, "{user}", ldap.EscapeFilter(user)),
+		[]string{"*", a.groupAttribute()},
+		nil,
+	)
+
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("could not search user: %w", err)
+	}
+
+	if result == nil || len(result.Entries) == 0 {
+		return nil, auth.ErrUnknownUser
+	}
+
+	if len(result.Entries) > 1 {
+		a.logger.Warn("User is not unique", "user", user)
+
+		return nil, ErrInvalidCredentials
+	}
+
+	return result.Entries[0], nil
+}
+
+// matchGroup returns the first group mapping matching the groups of the user
+func (a *Authenticator) matchGroup(groups []string) *confpar.LDAPGroup {
+	for _, mapping := range a.conf.Groups {
+		if mapping.Access == nil {
+			continue
+		}
+
+		if mapping.Group == anyGroup {
+			return mapping
+		}
+
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) || strings.EqualFold(groupName(group), mapping.Group) {
+				return mapping
+			}
+		}
+	}
+
+	return nil
+}
+
+// groupName returns the value of the first RDN of a group DN ("admins" for "cn=admins,ou=groups,dc=example,dc=com")
+func groupName(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return group
+	}
+
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+// buildAccess creates the user's access from the group template
+func buildAccess(template *confpar.Access, user string, entry *ldap.Entry) *confpar.Access {
+	values := map[string]string{}
+
+	for _, attr := range entry.Attributes {
+		if len(attr.Values) > 0 {
+			values[attr.Name] = attr.Values[0]
+		}
+	}
+
+	return template.Instantiate(user, values)
+}
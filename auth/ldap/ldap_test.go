@@ -0,0 +1,150 @@
+package ldap
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// fakeConn is a directory holding a few entries
+type fakeConn struct {
+	entries   []*ldap.Entry
+	passwords map[string]string // Passwords by DN
+	filters   []string
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	if pass, ok := c.passwords[username]; !ok || pass != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	return nil
+}
+
+func (c *fakeConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.filters = append(c.filters, searchRequest.Filter)
+
+	result := &ldap.SearchResult{}
+
+	for _, entry := range c.entries {
+		if searchRequest.Filter == "(uid="+entry.GetAttributeValue("uid")+")" {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	conn := &fakeConn{
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"alice"},
+				"cn":       {"Alice/Smith"},
+				"memberOf": {`cn=ftp\2c users,ou=groups,dc=example,dc=com`},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"uid": {"bob"},
+			}),
+		},
+		passwords: map[string]string{
+			"cn=service,dc=example,dc=com":          "service",
+			"uid=alice,ou=people,dc=example,dc=com": "alice",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob",
+		},
+	}
+
+	authenticator, err := New(&confpar.LDAP{
+		URL:          "ldap://localhost",
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN:       "dc=example,dc=com",
+		Groups: []*confpar.LDAPGroup{{
+			Group:  "ftp, users",
+			Access: &confpar.Access{Fs: "os", Params: map[string]string{"basePath": "/data/{cn}/{user}"}},
+		}},
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.dial = func() (ldapConn, error) { return conn, nil }
+
+	access, err := authenticator.Authenticate(nil, "alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The group is matched by the escaped name of its DN, and the attribute can't escape the base path
+	if access.User != "alice" || access.Params["basePath"] != "/data/Alice_Smith/alice" {
+		t.Fatal("Unexpected access:", access)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "bob", "bob"); !errors.Is(err, ErrNoMatchingGroup) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The special characters of the login can't alter the filter
+	if _, err = authenticator.Authenticate(nil, "*)(uid=alice", "alice"); !errors.Is(err, auth.ErrUnknownUser) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if filter := conn.filters[len(conn.filters)-1]; filter != `(uid=\2a\29\28uid=alice)` {
+		t.Fatal("Unexpected filter:", filter)
+	}
+}
+
+// TestDirectory runs against a real directory, like the glauth sample config described in the README:
+// LDAP_TEST_URL=ldap://localhost:3893 go test ./auth/ldap
+func TestDirectory(t *testing.T) {
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL isn't set")
+	}
+
+	authenticator, err := New(&confpar.LDAP{
+		URL:          url,
+		BindDN:       "cn=serviceuser,ou=svcaccts,dc=glauth,dc=com",
+		BindPassword: "mysecret",
+		BaseDN:       "dc=glauth,dc=com",
+		UserFilter:   "(&(objectClass=posixAccount)(cn={user}))",
+		Groups: []*confpar.LDAPGroup{{
+			Group:  "*",
+			Access: &confpar.Access{Fs: "os", Params: map[string]string{"basePath": "/data/{user}"}},
+		}},
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, err := authenticator.Authenticate(nil, "hackers", "dogood")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.Params["basePath"] != "/data/hackers" {
+		t.Fatal("Unexpected access:", access)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "hackers", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "nobody", "dogood"); !errors.Is(err, auth.ErrUnknownUser) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
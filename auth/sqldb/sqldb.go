@@ -0,0 +1,193 @@
+// Package sqldb provides a SQL database authentication layer
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+	"github.com/go-crypt/crypt"
+	_ "github.com/go-sql-driver/mysql" // MySQL driver
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
+	_ "modernc.org/sqlite"             // SQLite driver
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Supported drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+const (
+	defaultTable   = "ftp_users"
+	defaultTimeout = 10 * time.Second
+	maxIdleTime    = time.Minute
+)
+
+// ErrMissingDSN is returned when the dsn property isn't specified
+var ErrMissingDSN = errors.New("sql dsn must be specified")
+
+// ErrInvalidPassword is returned when the password doesn't match
+var ErrInvalidPassword = errors.New("invalid password")
+
+// UnsupportedDriverError is returned when the SQL driver isn't supported
+type UnsupportedDriverError struct {
+	error
+	Driver string
+}
+
+func (err UnsupportedDriverError) Error() string {
+	return fmt.Sprintf("Unsupported SQL driver: %s", err.Driver)
+}
+
+// Authenticator authenticates users against a table of a SQL database, which is queried at each login
+type Authenticator struct {
+	db      *sql.DB
+	query   string
+	timeout time.Duration
+	logger  log.Logger
+	decoder *crypt.Decoder
+}
+
+// New creates a SQL authenticator. The connection to the database is checked, but the server can start without it.
+func New(conf *confpar.SQL, logger log.Logger) (*Authenticator, error) {
+	if conf.DSN == "" {
+		return nil, ErrMissingDSN
+	}
+
+	driverName, placeholder := "", ""
+
+	switch conf.Driver {
+	case "", DriverSQLite:
+		driverName, placeholder = "sqlite", "?"
+	case DriverPostgres:
+		driverName, placeholder = "pgx", "$1"
+	case DriverMySQL:
+		driverName, placeholder = "mysql", "?"
+	default:
+		return nil, UnsupportedDriverError{Driver: conf.Driver}
+	}
+
+	query := conf.Query
+	if query == "" {
+		table := conf.Table
+		if table == "" {
+			table = defaultTable
+		}
+
+		query = "SELECT pass, fs, params, read_only, shared, max_sessions, options FROM " + table +
+			" WHERE username = " + placeholder
+	}
+
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	decoder, err := crypt.NewDecoderAll()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driverName, conf.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetConnMaxIdleTime(maxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		logger.Warn("Could not connect to the sql database", "driver", driverName, "err", err)
+	}
+
+	return &Authenticator{
+		db:      db,
+		query:   query,
+		timeout: timeout,
+		logger:  logger,
+		decoder: decoder,
+	}, nil
+}
+
+// Close closes the connections to the database, it's called when the authenticator is replaced by a config reload
+func (a *Authenticator) Close() error {
+	return a.db.Close()
+}
+
+// Authenticate checks the password against the hash of the user's row and returns the access it describes
+func (a *Authenticator) Authenticate(_ serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	var (
+		hash, fs         string
+		params, options  sql.NullString
+		readOnly, shared sql.NullBool
+		maxSessions      sql.NullInt64
+	)
+
+	err := a.db.QueryRowContext(ctx, a.query, user).Scan(
+		&hash, &fs, &params, &readOnly, &shared, &maxSessions, &options,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrUnknownUser
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := a.decoder.Decode(hash)
+	if err != nil {
+		a.logger.Warn("Unsupported password hash", "user", user, "err", err)
+
+		return nil, ErrInvalidPassword
+	}
+
+	ok, err := digest.MatchAdvanced(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidPassword
+	}
+
+	// The other fields of the access are read from the options
+	access := &confpar.Access{}
+
+	if options.String != "" {
+		if err := json.Unmarshal([]byte(options.String), access); err != nil {
+			return nil, fmt.Errorf("invalid options of %s: %w", user, err)
+		}
+
+		if err := auth.CheckExternalAccess(access); err != nil {
+			return nil, fmt.Errorf("invalid options of %s: %w", user, err)
+		}
+	}
+
+	if params.String != "" {
+		if err := json.Unmarshal([]byte(params.String), &access.Params); err != nil {
+			return nil, fmt.Errorf("invalid params of %s: %w", user, err)
+		}
+	}
+
+	access.Fs = fs
+	access.ReadOnly = readOnly.Bool
+	access.Shared = shared.Bool
+	access.MaxSessions = int(maxSessions.Int64)
+
+	return access.Instantiate(user, nil), nil
+}
@@ -0,0 +1,77 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestAuthenticate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "users.db")
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = db.Close() }()
+
+	_, err = db.Exec(`CREATE TABLE ftp_users (
+		username TEXT PRIMARY KEY, pass TEXT NOT NULL, fs TEXT NOT NULL, params TEXT,
+		read_only BOOLEAN, shared BOOLEAN, max_sessions INTEGER, options TEXT
+	);
+	INSERT INTO ftp_users VALUES ('alice', '$1$salt$qJH7.N4xYta3aEG/dfqo/0', 'os', '{"basePath": "/srv/{user}"}',
+		1, 0, 3, '{"quota": {"max_bytes": 1000}}');
+	INSERT INTO ftp_users (username, pass, fs) VALUES ('bob', '$1$salt$qJH7.N4xYta3aEG/dfqo/0', 'os');
+	INSERT INTO ftp_users (username, pass, fs, options) VALUES ('mallory', '$1$salt$qJH7.N4xYta3aEG/dfqo/0', 'os',
+		'{"on_upload": ["sh", "-c", "id"]}');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := New(&confpar.SQL{DSN: dsn}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := authenticator.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	access, err := authenticator.Authenticate(nil, "alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.User != "alice" || access.Params["basePath"] != "/srv/alice" || !access.ReadOnly ||
+		access.MaxSessions != 3 || access.Quota == nil || access.Quota.MaxBytes != 1000 {
+		t.Fatal("Unexpected access:", access)
+	}
+
+	if access, err = authenticator.Authenticate(nil, "bob", "password"); err != nil || access.ReadOnly {
+		t.Fatal("Unexpected access:", access, err)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "alice", "wrong"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "carol", "password"); !errors.Is(err, auth.ErrUnknownUser) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The database can't make the server run commands
+	var restricted auth.RestrictedFieldError
+	if _, err = authenticator.Authenticate(nil, "mallory", "password"); !errors.As(err, &restricted) ||
+		restricted.Field != "on_upload" {
+		t.Fatal("Unexpected error:", err)
+	}
+}
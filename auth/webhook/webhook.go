@@ -0,0 +1,360 @@
+// Package webhook provides an HTTP webhook authentication layer
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
+)
+
+// ErrMissingURL is returned when the url property isn't specified
+var ErrMissingURL = errors.New("webhook url must be specified")
+
+// ErrRejected is returned when the webhook refused the credentials with a 401 or 403 status
+var ErrRejected = errors.New("rejected by the webhook")
+
+// ErrInvalidResponse is returned when the response of the webhook can't be used, it isn't retried
+var ErrInvalidResponse = errors.New("invalid webhook response")
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+	pruneInterval     = time.Minute
+	maxIdleConns      = 16
+	maxResponseSize   = 1 << 20
+)
+
+// UnexpectedStatusError is returned when the webhook replied with an unexpected status
+type UnexpectedStatusError struct {
+	error
+	Status int
+}
+
+func (err UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("Unexpected webhook status: %d", err.Status)
+}
+
+// entry is a cached response of the webhook
+type entry struct {
+	access  *confpar.Access // nil if the credentials were rejected
+	err     error
+	expires time.Time // End of the freshness of the entry
+	stale   time.Time // End of the use of the entry when the webhook can't be reached
+}
+
+// Authenticator authenticates users by calling a webhook returning their access. The responses can be cached.
+type Authenticator struct {
+	conf      *confpar.AccessesWebhook
+	logger    log.Logger
+	client    *http.Client
+	cacheKey  []byte // Random key of the password hashes of the cache
+	mu        sync.Mutex
+	cache     map[string]*entry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New creates a webhook authenticator, its HTTP connections are kept alive and reused
+func New(conf *confpar.AccessesWebhook, logger log.Logger) (*Authenticator, error) {
+	if conf.URL == "" {
+		return nil, ErrMissingURL
+	}
+
+	cacheKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(cacheKey); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // always a Transport
+	transport.MaxIdleConnsPerHost = maxIdleConns
+
+	return &Authenticator{
+		conf:     conf,
+		logger:   logger,
+		client:   &http.Client{Transport: transport},
+		cacheKey: cacheKey,
+		cache:    make(map[string]*entry),
+		now:      time.Now,
+	}, nil
+}
+
+// Authenticate returns the access provided by the webhook, or by the cache
+func (a *Authenticator) Authenticate(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+	req := newRequest(cc, user, pass)
+	key := a.key(req)
+	now := a.now()
+
+	cached := a.get(key)
+	if cached != nil && now.Before(cached.expires) {
+		return cached.result()
+	}
+
+	access, err := a.call(req)
+
+	switch {
+	case err == nil:
+		a.put(key, &entry{access: access}, a.conf.CacheTTL)
+	case errors.Is(err, ErrRejected), errors.Is(err, auth.ErrUnknownUser):
+		a.put(key, &entry{err: err}, a.conf.NegativeCacheTTL)
+	case retryable(err) && cached != nil && now.Before(cached.stale):
+		// The webhook can't be reached, the last response is used instead
+		a.logger.Warn("Webhook unavailable, using a stale response", "user", user, "err", err)
+
+		return cached.result()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return copyAccess(access)
+}
+
+// key identifies the credentials and the client context sent to the webhook in the cache, without keeping the
+// password. The client ID is left out, it changes with each connection.
+func (a *Authenticator) key(req *request) string {
+	mac := hmac.New(sha256.New, a.cacheKey)
+	_, _ = fmt.Fprintf(mac, "%q %q %q %t %q", req.User, req.Pass, req.RemoteIP, req.TLS, req.ClientVersion)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *entry) result() (*confpar.Access, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return copyAccess(e.access)
+}
+
+// copyAccess prevents the cached accesses from being modified by their users. The access is copied through JSON, so
+// that none of its maps, slices and pointers is shared.
+func copyAccess(access *confpar.Access) (*confpar.Access, error) {
+	data, err := json.Marshal(access)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := new(confpar.Access)
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, err
+	}
+
+	return copied, nil
+}
+
+func (a *Authenticator) get(key string) *entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.cache[key]
+}
+
+// put caches a response for a duration, nothing is cached if it's 0
+func (a *Authenticator) put(key string, e *entry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	now := a.now()
+	e.expires = now.Add(ttl)
+	e.stale = e.expires
+
+	if e.err == nil {
+		e.stale = e.expires.Add(a.conf.StaleTTL)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.prune(now)
+	a.cache[key] = e
+}
+
+// prune forgets the entries which can't be used anymore, it must be called with the lock held
+func (a *Authenticator) prune(now time.Time) {
+	if now.Sub(a.lastPrune) < pruneInterval {
+		return
+	}
+
+	a.lastPrune = now
+
+	for key, e := range a.cache {
+		if now.After(e.stale) {
+			delete(a.cache, key)
+		}
+	}
+}
+
+// request is the payload posted to the webhook
+type request struct {
+	User          string `json:"user"`
+	Pass          string `json:"pass"`
+	RemoteIP      string `json:"remote_ip,omitempty"`
+	ClientID      uint32 `json:"client_id,omitempty"`
+	TLS           bool   `json:"tls"`                      // The control connection is encrypted
+	ClientVersion string `json:"client_version,omitempty"` // Announced with the CLNT command
+}
+
+func newRequest(cc serverlib.ClientContext, user, pass string) *request {
+	req := &request{User: user, Pass: pass}
+
+	if cc != nil {
+		req.RemoteIP = remoteIP(cc.RemoteAddr())
+		req.ClientID = cc.ID()
+		req.TLS = cc.HasTLSForControl()
+		req.ClientVersion = cc.GetClientVersion()
+	}
+
+	return req
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+
+	if addr == nil {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+
+	return addr.String()
+}
+
+// retryable tells if another URL or a later call could succeed
+func retryable(err error) bool {
+	var statusErr UnexpectedStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= http.StatusInternalServerError
+	}
+
+	return !errors.Is(err, ErrRejected) && !errors.Is(err, auth.ErrUnknownUser) && !errors.Is(err, ErrInvalidResponse)
+}
+
+// call posts the credentials to the URLs of the webhook, until one of them replies. They are all called again after
+// a growing delay if they all failed, up to max_retries times.
+func (a *Authenticator) call(req *request) (*confpar.Access, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := append([]string{a.conf.URL}, a.conf.FallbackURLs...)
+
+	delay := a.conf.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		for _, url := range urls {
+			var access *confpar.Access
+			if access, err = a.post(url, payload); err == nil || !retryable(err) {
+				return access, err
+			}
+
+			a.logger.Warn("Webhook call failed", "url", url, "attempt", attempt+1, "err", err)
+		}
+
+		if attempt >= a.conf.MaxRetries {
+			return nil, err
+		}
+
+		time.Sleep(delay)
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// post sends the payload to a URL and returns the access of its response
+func (a *Authenticator) post(url string, payload []byte) (*confpar.Access, error) {
+	timeout := a.conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	// Timeout is implemented with context termination
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for key, value := range a.conf.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if a.conf.Secret != "" {
+		req.Header.Set(events.SignatureHeader, events.Sign(a.conf.Secret, payload))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			a.logger.Debug("Problem closing webhook response", "err", errClose)
+		}
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrRejected
+	case http.StatusNotFound:
+		return nil, auth.ErrUnknownUser
+	default:
+		return nil, UnexpectedStatusError{Status: resp.StatusCode}
+	}
+
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidResponse, maxResponseSize)
+	}
+
+	access := new(confpar.Access)
+	if err := json.Unmarshal(body, access); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	if err := auth.CheckExternalAccess(access); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return access, nil
+}
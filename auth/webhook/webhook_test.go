@@ -0,0 +1,331 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+
+	var down atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		var credentials request
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil || down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		if credentials.Pass != "pass" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"fs": "os", "params": {"basePath": "/tmp"}}`))
+	}))
+	defer server.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{
+		URL:              server.URL,
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: time.Second,
+		StaleTTL:         time.Hour,
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	for range 2 {
+		if access, errAuth := authenticator.Authenticate(nil, "user", "pass"); errAuth != nil || access.Fs != "os" {
+			t.Fatal("Unexpected result:", access, errAuth)
+		}
+
+		if _, errAuth := authenticator.Authenticate(nil, "user", "wrong"); !errors.Is(errAuth, ErrRejected) {
+			t.Fatal("Unexpected error:", errAuth)
+		}
+	}
+
+	if calls.Load() != 2 {
+		t.Fatal("The responses weren't cached:", calls.Load())
+	}
+
+	// The expired access is still used while the webhook fails, not the rejection
+	now = now.Add(2 * time.Minute)
+	down.Store(true)
+
+	if _, errAuth := authenticator.Authenticate(nil, "user", "pass"); errAuth != nil {
+		t.Fatal("The stale access wasn't used:", errAuth)
+	}
+
+	var statusErr UnexpectedStatusError
+	if _, errAuth := authenticator.Authenticate(nil, "user", "wrong"); !errors.As(errAuth, &statusErr) {
+		t.Fatal("Unexpected error:", errAuth)
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	if _, errAuth := authenticator.Authenticate(nil, "user", "pass"); !errors.As(errAuth, &statusErr) {
+		t.Fatal("The stale access was used for too long:", errAuth)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var failures atomic.Int32
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		failures.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	var received request
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Header.Get(events.SignatureHeader) != events.Sign("secret", body) || json.Unmarshal(body, &received) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		// The first call fails, to be retried
+		if failures.Load() < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	defer fallback.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{
+		URL:          failing.URL,
+		FallbackURLs: []string{fallback.URL},
+		MaxRetries:   1,
+		RetryDelay:   time.Millisecond,
+		Secret:       "secret",
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = authenticator.Authenticate(nil, "user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	if failures.Load() != 2 || received.User != "user" || received.Pass != "pass" {
+		t.Fatal("Unexpected calls:", failures.Load(), received)
+	}
+}
+
+func TestUnknownUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var fallbackCalls atomic.Int32
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fallbackCalls.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer fallback.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{URL: server.URL, FallbackURLs: []string{fallback.URL}},
+		lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next authenticator of the chain decides
+	if _, err = authenticator.Authenticate(nil, "user", "pass"); !errors.Is(err, auth.ErrUnknownUser) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if fallbackCalls.Load() != 0 {
+		t.Fatal("The fallback was called")
+	}
+}
+
+func TestInvalidResponse(t *testing.T) {
+	var body atomic.Value
+
+	body.Store(`{"fs": "os"}`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body.Load().(string)))
+	}))
+	defer server.Close()
+
+	var fallbackCalls atomic.Int32
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fallbackCalls.Add(1)
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	defer fallback.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{
+		URL:          server.URL,
+		FallbackURLs: []string{fallback.URL},
+		CacheTTL:     time.Minute,
+		StaleTTL:     time.Hour,
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	if _, err = authenticator.Authenticate(nil, "user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A webhook replying garbage isn't worked around with the fallbacks or the stale responses, nor can it make the
+	// server run commands
+	now = now.Add(2 * time.Minute)
+
+	for _, invalid := range []string{
+		"<html>",
+		strings.Repeat(" ", maxResponseSize) + "{}",
+		`{"fs": "os", "on_upload": ["sh", "-c", "id"]}`,
+		`{"fs": "os", "totp_secret": "JBSWY3DPEHPK3PXP"}`,
+	} {
+		body.Store(invalid)
+
+		if _, err = authenticator.Authenticate(nil, "user", "pass"); !errors.Is(err, ErrInvalidResponse) {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	if fallbackCalls.Load() != 0 {
+		t.Fatal("The fallback was called")
+	}
+}
+
+func TestCachedAccessCopy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"fs": "os", "params": {"basePath": "/tmp"}, "quota": {"max_bytes": 1000},
+			"allowed_ips": ["10.0.0.0/8"], "valid_until": "2100-01-01T00:00:00Z"}`))
+	}))
+	defer server.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{URL: server.URL, CacheTTL: time.Minute},
+		lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, err := authenticator.Authenticate(nil, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access.Params["basePath"] = "/"
+	access.Quota.MaxBytes = 0
+	access.AllowedIPs[0] = "0.0.0.0/0"
+	*access.ValidUntil = time.Time{}
+
+	// The cached access isn't modified
+	if access, err = authenticator.Authenticate(nil, "user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	if access.Params["basePath"] != "/tmp" || access.Quota.MaxBytes != 1000 || access.AllowedIPs[0] != "10.0.0.0/8" ||
+		access.ValidUntil.Year() != 2100 {
+		t.Fatal("The cached access was modified:", access)
+	}
+}
+
+// clientContext is a client connected from an IP
+type clientContext struct {
+	serverlib.ClientContext
+	ip string
+}
+
+func (cc *clientContext) ID() uint32 {
+	return 1
+}
+
+func (cc *clientContext) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(cc.ip), Port: 1234}
+}
+
+func (cc *clientContext) HasTLSForControl() bool {
+	return false
+}
+
+func (cc *clientContext) GetClientVersion() string {
+	return ""
+}
+
+func TestCacheContext(t *testing.T) {
+	var calls atomic.Int32
+
+	// The webhook only accepts the user from the 10.0.0.0/8 network
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		var credentials request
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil ||
+			!strings.HasPrefix(credentials.RemoteIP, "10.") {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"fs": "os"}`))
+	}))
+	defer server.Close()
+
+	authenticator, err := New(&confpar.AccessesWebhook{
+		URL:              server.URL,
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: time.Minute,
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, errAuth := authenticator.Authenticate(&clientContext{ip: "10.1.2.3"}, "user", "pass"); errAuth != nil {
+			t.Fatal(errAuth)
+		}
+
+		_, errAuth := authenticator.Authenticate(&clientContext{ip: "192.0.2.1"}, "user", "pass")
+		if !errors.Is(errAuth, ErrRejected) {
+			t.Fatal("The access was cached for another IP:", errAuth)
+		}
+	}
+
+	if calls.Load() != 2 {
+		t.Fatal("Unexpected calls:", calls.Load())
+	}
+}
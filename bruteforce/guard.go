@@ -0,0 +1,317 @@
+// Package bruteforce protects the authentication against password guessing, with growing delays and temporary bans
+// of the IPs and users failing to log in
+package bruteforce
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/ipfilter"
+)
+
+const (
+	defaultMaxFailures    = 5
+	defaultWindow         = 10 * time.Minute
+	defaultBanDuration    = 15 * time.Minute
+	defaultMaxBanDuration = 24 * time.Hour
+	defaultDelay          = time.Second
+	defaultMaxDelay       = 30 * time.Second
+	pruneInterval         = time.Minute
+)
+
+// Prefixes of the keys of the tracked IPs and users
+const (
+	ipPrefix   = "ip:"
+	userPrefix = "user:"
+)
+
+// ErrIPBanned is returned when an IP is temporarily banned
+var ErrIPBanned = errors.New("IP temporarily banned")
+
+// ErrUserBanned is returned when a user is temporarily banned
+var ErrUserBanned = errors.New("user temporarily banned")
+
+// entry tracks the failures and bans of an IP or a user
+type entry struct {
+	failures    int       // Failures in the current window
+	windowStart time.Time // Time of the first failure of the window
+	bans        int       // Consecutive bans, they double the ban duration
+	bannedUntil time.Time
+}
+
+// Ban describes a banned IP or user
+type Ban struct {
+	Key   string    `json:"key"`   // ip:<address> or user:<name>
+	Bans  int       `json:"bans"`  // Consecutive bans
+	Until time.Time `json:"until"` // End of the ban
+}
+
+// Guard tracks the failed logins
+type Guard struct {
+	logger    log.Logger
+	mu        sync.Mutex
+	conf      *confpar.BruteForce // nil if disabled
+	neverBan  ipfilter.List
+	entries   map[string]*entry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewGuard creates a guard, it's disabled if conf is nil. The bans of the ban file are restored.
+func NewGuard(conf *confpar.BruteForce, logger log.Logger) (*Guard, error) {
+	g := &Guard{
+		logger:  logger,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+
+	if err := g.Update(conf); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Update changes the settings, the current failures and bans are kept
+func (g *Guard) Update(conf *confpar.BruteForce) error {
+	var neverBan ipfilter.List
+
+	if conf != nil {
+		var err error
+
+		if neverBan, err = ipfilter.Parse(conf.NeverBan); err != nil {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	load := conf != nil && conf.BanFile != "" && (g.conf == nil || g.conf.BanFile != conf.BanFile)
+	g.conf, g.neverBan = conf, neverBan
+
+	if load {
+		g.load()
+	}
+
+	return nil
+}
+
+func orDefault[T int | time.Duration](value, defaultValue T) T {
+	if value <= 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// Check returns an error if the IP or the user is banned
+func (g *Guard) Check(ip, user string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conf == nil || g.neverBan.Contains(ip) {
+		return nil
+	}
+
+	now := g.now()
+
+	if e := g.entries[ipPrefix+ip]; e != nil && now.Before(e.bannedUntil) {
+		return ErrIPBanned
+	}
+
+	if e := g.entries[userPrefix+user]; user != "" && e != nil && now.Before(e.bannedUntil) {
+		return ErrUserBanned
+	}
+
+	return nil
+}
+
+// Failure records a failed login and returns the delay to apply before replying
+func (g *Guard) Failure(ip, user string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conf == nil || g.neverBan.Contains(ip) {
+		return 0
+	}
+
+	g.prune()
+
+	failures := g.fail(ipPrefix + ip)
+	if user != "" {
+		failures = max(failures, g.fail(userPrefix+user))
+	}
+
+	// The delay doubles with each failure
+	delay := orDefault(g.conf.Delay, defaultDelay)
+	maxDelay := orDefault(g.conf.MaxDelay, defaultMaxDelay)
+
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// fail counts a failure and bans the IP or user once it reaches the limit, it returns the failures of the window
+func (g *Guard) fail(key string) int {
+	now := g.now()
+
+	e := g.entries[key]
+	if e == nil {
+		e = &entry{}
+		g.entries[key] = e
+	}
+
+	if now.Sub(e.windowStart) > orDefault(g.conf.Window, defaultWindow) {
+		e.failures, e.windowStart = 0, now
+	}
+
+	e.failures++
+	failures := e.failures
+
+	if failures >= orDefault(g.conf.MaxFailures, defaultMaxFailures) {
+		// The ban duration doubles with each consecutive ban
+		duration := orDefault(g.conf.BanDuration, defaultBanDuration)
+		maxDuration := orDefault(g.conf.MaxBanDuration, defaultMaxBanDuration)
+
+		for i := 0; i < e.bans && duration < maxDuration; i++ {
+			duration *= 2
+		}
+
+		duration = min(duration, maxDuration)
+		e.bans++
+		e.bannedUntil = now.Add(duration)
+		e.failures = 0
+
+		g.logger.Warn("Banned", "key", key, "duration", duration, "bans", e.bans)
+		g.save()
+	}
+
+	return failures
+}
+
+// Success resets the failures of the IP and the user, their ban durations aren't reset
+func (g *Guard) Success(ip, user string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range []string{ipPrefix + ip, userPrefix + user} {
+		if e := g.entries[key]; e != nil {
+			e.failures = 0
+		}
+	}
+}
+
+// Bans returns the current bans, sorted by key
+func (g *Guard) Bans() []*Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.bans()
+}
+
+// Unban lifts the ban of an IP (ip:<address>) or a user (user:<name>), and forgets its previous bans
+func (g *Guard) Unban(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.entries[key]; ok {
+		delete(g.entries, key)
+		g.logger.Info("Unbanned", "key", key)
+		g.save()
+	}
+}
+
+// bans must be called with the lock held
+func (g *Guard) bans() []*Ban {
+	now := g.now()
+	bans := make([]*Ban, 0)
+
+	for key, e := range g.entries {
+		if now.Before(e.bannedUntil) {
+			bans = append(bans, &Ban{Key: key, Bans: e.bans, Until: e.bannedUntil})
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Key < bans[j].Key })
+
+	return bans
+}
+
+// prune forgets the entries without recent failure nor ban, it must be called with the lock held
+func (g *Guard) prune() {
+	now := g.now()
+	if now.Sub(g.lastPrune) < pruneInterval {
+		return
+	}
+
+	g.lastPrune = now
+	window := orDefault(g.conf.Window, defaultWindow)
+	maxBanDuration := orDefault(g.conf.MaxBanDuration, defaultMaxBanDuration)
+
+	for key, e := range g.entries {
+		// The bans count is kept for a while to grow the duration of the next ban
+		if now.Sub(e.windowStart) > window && now.Sub(e.bannedUntil) > maxBanDuration {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// load restores the bans of the ban file, it must be called with the lock held
+func (g *Guard) load() {
+	data, err := os.ReadFile(g.conf.BanFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			g.logger.Warn("Could not read ban file", "file", g.conf.BanFile, "err", err)
+		}
+
+		return
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		g.logger.Warn("Invalid ban file", "file", g.conf.BanFile, "err", err)
+
+		return
+	}
+
+	for _, ban := range bans {
+		g.entries[ban.Key] = &entry{bans: ban.Bans, bannedUntil: ban.Until}
+	}
+
+	g.logger.Info("Loaded bans", "file", g.conf.BanFile, "nbBans", len(bans))
+}
+
+// save persists the current bans, it must be called with the lock held
+func (g *Guard) save() {
+	if g.conf == nil || g.conf.BanFile == "" {
+		return
+	}
+
+	data, err := json.Marshal(g.bans())
+	if err != nil {
+		return
+	}
+
+	temp := g.conf.BanFile + ".tmp"
+
+	if err = os.MkdirAll(filepath.Dir(g.conf.BanFile), 0o750); err == nil {
+		if err = os.WriteFile(temp, data, 0o600); err == nil {
+			err = os.Rename(temp, g.conf.BanFile)
+		}
+	}
+
+	if err != nil {
+		g.logger.Warn("Could not save ban file", "file", g.conf.BanFile, "err", err)
+	}
+}
@@ -0,0 +1,80 @@
+package bruteforce
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestGuard(t *testing.T) {
+	conf := &confpar.BruteForce{
+		MaxFailures: 3,
+		BanDuration: time.Minute,
+		Delay:       time.Second,
+		MaxDelay:    3 * time.Second,
+		BanFile:     filepath.Join(t.TempDir(), "bans.json"),
+		NeverBan:    []string{"10.0.0.0/8"},
+	}
+
+	guard, err := NewGuard(conf, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		if delay := guard.Failure("1.2.3.4", "test"); delay != expected {
+			t.Fatalf("Unexpected delay of failure %d: %s", i+1, delay)
+		}
+	}
+
+	if delay := guard.Failure("1.2.3.4", "other"); delay != 3*time.Second {
+		t.Fatal("The delay should be capped:", delay)
+	}
+
+	if err := guard.Check("1.2.3.4", "another"); !errors.Is(err, ErrIPBanned) {
+		t.Fatal("The IP should be banned:", err)
+	}
+
+	if err := guard.Check("10.1.2.3", "test"); err != nil || guard.Failure("10.1.2.3", "test") != 0 {
+		t.Fatal("The allowlisted IPs should never be delayed nor banned")
+	}
+
+	// The bans are restored after a restart
+	restored, err := NewGuard(conf, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.Check("1.2.3.4", ""); !errors.Is(err, ErrIPBanned) {
+		t.Fatal("The ban wasn't restored:", err)
+	}
+
+	// The next ban of the IP lasts twice as long
+	now = now.Add(2 * time.Minute)
+
+	if err := guard.Check("1.2.3.4", ""); err != nil {
+		t.Fatal("The ban should have expired:", err)
+	}
+
+	for range 3 {
+		guard.Failure("1.2.3.4", "")
+	}
+
+	if bans := guard.Bans(); len(bans) != 1 || bans[0].Until != now.Add(2*time.Minute) {
+		t.Fatalf("Unexpected bans: %+v", bans)
+	}
+
+	guard.Unban("ip:1.2.3.4")
+
+	if err := guard.Check("1.2.3.4", ""); err != nil {
+		t.Fatal("The IP should be unbanned:", err)
+	}
+}
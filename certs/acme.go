@@ -0,0 +1,461 @@
+// Package certs provides the TLS certificates served by the server and checks the client certificates
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+	"golang.org/x/crypto/acme"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// ACME challenge types
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+const (
+	letsEncryptURL     = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultRenewBefore = 30 * 24 * time.Hour
+	defaultHTTPAddress = ":80"
+	checkInterval      = 12 * time.Hour
+	retryInterval      = time.Hour
+	orderTimeout       = 5 * time.Minute
+	httpReadTimeout    = 10 * time.Second
+)
+
+// ErrMissingDomains is returned when no domain is specified
+var ErrMissingDomains = errors.New("acme domains must be specified")
+
+// ErrMissingCacheDir is returned when no cache directory is specified
+var ErrMissingCacheDir = errors.New("acme cache_dir must be specified")
+
+// ErrMissingDNSHook is returned when the dns-01 challenge is used without hook
+var ErrMissingDNSHook = errors.New("acme dns_hook must be specified for the dns-01 challenge")
+
+// ErrCertificateNotReady is returned while the first certificate hasn't been obtained
+var ErrCertificateNotReady = errors.New("certificate not obtained yet")
+
+// UnsupportedChallengeError is returned when a challenge type isn't supported
+type UnsupportedChallengeError struct {
+	error
+	Type string
+}
+
+func (err UnsupportedChallengeError) Error() string {
+	return fmt.Sprintf("Unsupported ACME challenge: %s", err.Type)
+}
+
+// ACME obtains and renews a certificate from an ACME server like Let's Encrypt
+type ACME struct {
+	conf        *confpar.ACME
+	logger      log.Logger
+	client      *acme.Client
+	challenge   string
+	renewBefore time.Duration
+	cert        atomic.Pointer[tls.Certificate]
+	tokens      sync.Map // HTTP-01 challenge responses by path
+}
+
+// NewACME creates an ACME certificates manager, Start must be called to obtain the certificate
+func NewACME(conf *confpar.ACME, logger log.Logger) (*ACME, error) {
+	if len(conf.Domains) == 0 {
+		return nil, ErrMissingDomains
+	}
+
+	if conf.CacheDir == "" {
+		return nil, ErrMissingCacheDir
+	}
+
+	m := &ACME{
+		conf:        conf,
+		logger:      logger,
+		challenge:   conf.Challenge,
+		renewBefore: conf.RenewBefore,
+	}
+
+	switch m.challenge {
+	case "":
+		m.challenge = ChallengeHTTP01
+	case ChallengeHTTP01:
+	case ChallengeDNS01:
+		if len(conf.DNSHook) == 0 {
+			return nil, ErrMissingDNSHook
+		}
+	default:
+		return nil, &UnsupportedChallengeError{Type: conf.Challenge}
+	}
+
+	if m.renewBefore <= 0 {
+		m.renewBefore = defaultRenewBefore
+	}
+
+	directoryURL := conf.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = letsEncryptURL
+	}
+
+	httpClient, err := newDirectoryClient(conf.DirectoryCA)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(conf.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create acme cache_dir: %w", err)
+	}
+
+	key, err := m.loadOrCreateKey(filepath.Join(conf.CacheDir, "account.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	m.client = &acme.Client{
+		Key:          key,
+		DirectoryURL: directoryURL,
+		HTTPClient:   httpClient,
+		UserAgent:    "ftpserver",
+	}
+
+	return m, nil
+}
+
+// newDirectoryClient returns the HTTP client of the ACME server, trusting an additional CA if specified
+func newDirectoryClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+
+	caBytes, err := os.ReadFile(caFile) //nolint:gosec // file of the config
+	if err != nil {
+		return nil, fmt.Errorf("could not load acme directory_ca: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pool.AppendCertsFromPEM(caBytes)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (m *ACME) loadOrCreateKey(file string) (crypto.Signer, error) {
+	if keyBytes, err := os.ReadFile(file); err == nil { //nolint:gosec // file of our cache
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, fmt.Errorf("invalid key file: %s", file) //nolint:goerr113
+		}
+
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m *ACME) certFiles() (string, string) {
+	name := utils.SanitizePathElement(m.conf.Domains[0])
+
+	return filepath.Join(m.conf.CacheDir, name+".crt"), filepath.Join(m.conf.CacheDir, name+".key")
+}
+
+// Start loads the cached certificate, and obtains and renews it in the background
+func (m *ACME) Start() error {
+	certFile, keyFile := m.certFiles()
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		m.cert.Store(&cert)
+		m.logger.Info("Loaded cached certificate", "domains", strings.Join(m.conf.Domains, ","), "expiry", cert.Leaf.NotAfter)
+	}
+
+	if m.challenge == ChallengeHTTP01 {
+		if err := m.serveHTTP01(); err != nil {
+			return err
+		}
+	}
+
+	go m.renewLoop()
+
+	return nil
+}
+
+// GetCertificate returns the current certificate, it's meant to be used in a tls.Config
+func (m *ACME) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := m.cert.Load()
+	if cert == nil {
+		return nil, ErrCertificateNotReady
+	}
+
+	return cert, nil
+}
+
+func (m *ACME) renewLoop() {
+	for {
+		wait := checkInterval
+
+		if err := m.renew(); err != nil {
+			m.logger.Error("Could not obtain certificate", "domains", strings.Join(m.conf.Domains, ","), "err", err)
+
+			wait = retryInterval
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// renew obtains a new certificate if there's none yet or if the current one expires soon
+func (m *ACME) renew() error {
+	if cert := m.cert.Load(); cert != nil && time.Until(cert.Leaf.NotAfter) >= m.renewBefore {
+		return nil
+	}
+
+	return m.obtain()
+}
+
+func (m *ACME) serveHTTP01() error {
+	address := m.conf.HTTPAddress
+	if address == "" {
+		address = defaultHTTPAddress
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not listen for acme http-01 challenges: %w", err)
+	}
+
+	server := &http.Server{
+		ReadHeaderTimeout: httpReadTimeout,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response, ok := m.tokens.Load(r.URL.Path)
+			if !ok {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(response.(string))) //nolint:forcetypeassert
+		}),
+	}
+
+	m.logger.Info("Serving acme http-01 challenges", "address", listener.Addr())
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			m.logger.Error("Problem serving acme http-01 challenges", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// obtain orders a new certificate
+func (m *ACME) obtain() error {
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+
+	account := &acme.Account{}
+	if m.conf.Email != "" {
+		account.Contact = []string{"mailto:" + m.conf.Email}
+	}
+
+	if _, err := m.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("could not register account: %w", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.conf.Domains...))
+	if err != nil {
+		return fmt.Errorf("could not create order: %w", err)
+	}
+
+	// The URL isn't always part of the responses
+	orderURL := order.URI
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, authzURL); err != nil {
+			return err
+		}
+	}
+
+	if order, err = m.client.WaitOrder(ctx, orderURL); err != nil {
+		return fmt.Errorf("order failed: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.conf.Domains[0]},
+		DNSNames: m.conf.Domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// Some servers reply to the finalization with a processing order but without its URL
+		if valid, errWait := m.client.WaitOrder(ctx, orderURL); errWait == nil && valid.CertURL != "" {
+			chain, err = m.client.FetchCert(ctx, valid.CertURL, true)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not finalize order: %w", err)
+	}
+
+	return m.store(chain, key)
+}
+
+// authorize completes the challenge of an authorization
+func (m *ACME) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+
+	for _, c := range authz.Challenges {
+		if c.Type == m.challenge {
+			challenge = c
+		}
+	}
+
+	if challenge == nil {
+		return &UnsupportedChallengeError{Type: m.challenge}
+	}
+
+	domain := authz.Identifier.Value
+
+	switch m.challenge {
+	case ChallengeHTTP01:
+		response, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+
+		path := m.client.HTTP01ChallengePath(challenge.Token)
+		m.tokens.Store(path, response)
+
+		defer m.tokens.Delete(path)
+	case ChallengeDNS01:
+		record, err := m.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		if err := m.runDNSHook(ctx, "present", fqdn, record); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := m.runDNSHook(context.Background(), "cleanup", fqdn, record); err != nil {
+				m.logger.Warn("Could not clean up dns-01 challenge", "domain", domain, "err", err)
+			}
+		}()
+	}
+
+	if _, err := m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("could not accept challenge for %s: %w", domain, err)
+	}
+
+	if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %w", domain, err)
+	}
+
+	return nil
+}
+
+// runDNSHook calls the hook with the action (present or cleanup), the record name and its value
+func (m *ACME) runDNSHook(ctx context.Context, action, fqdn, value string) error {
+	args := append(append([]string{}, m.conf.DNSHook[1:]...), action, fqdn, value)
+
+	output, err := exec.CommandContext(ctx, m.conf.DNSHook[0], args...).CombinedOutput() //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("dns hook %s failed: %w: %s", action, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// store caches a new certificate and starts serving it
+func (m *ACME) store(chain [][]byte, key *ecdsa.PrivateKey) error {
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	certFile, keyFile := m.certFiles()
+
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		return err
+	}
+
+	m.cert.Store(&cert)
+	m.logger.Info("Obtained certificate", "domains", strings.Join(m.conf.Domains, ","), "expiry", cert.Leaf.NotAfter)
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestNewACME(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewACME(&confpar.ACME{CacheDir: dir}, lognoop.NewNoOpLogger()); !errors.Is(err, ErrMissingDomains) {
+		t.Fatal("Domains should be required:", err)
+	}
+
+	if _, err := NewACME(&confpar.ACME{Domains: []string{"example.com"}, CacheDir: dir, Challenge: "dns-01"},
+		lognoop.NewNoOpLogger()); !errors.Is(err, ErrMissingDNSHook) {
+		t.Fatal("The DNS hook should be required:", err)
+	}
+
+	conf := &confpar.ACME{Domains: []string{"example.com"}, CacheDir: dir}
+
+	m, err := NewACME(conf, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetCertificate(nil); !errors.Is(err, ErrCertificateNotReady) {
+		t.Fatal("No certificate should be served yet:", err)
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, "account.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account key is reused
+	if _, err := NewACME(conf, lognoop.NewNoOpLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	if key2, _ := os.ReadFile(filepath.Join(dir, "account.key")); string(key) != string(key2) {
+		t.Fatal("The account key was regenerated")
+	}
+}
+
+// TestACMEIssuance obtains and renews a certificate from Pebble (https://github.com/letsencrypt/pebble), started with
+// its test config:
+// ACME_TEST_DIRECTORY_URL=https://localhost:14000/dir ACME_TEST_CA=test/certs/pebble.minica.pem go test ./certs
+func TestACMEIssuance(t *testing.T) {
+	directoryURL := os.Getenv("ACME_TEST_DIRECTORY_URL")
+	if directoryURL == "" {
+		t.Skip("ACME_TEST_DIRECTORY_URL isn't set")
+	}
+
+	// Pebble validates the http-01 challenges on port 5002
+	conf := &confpar.ACME{
+		Domains:      []string{"localhost"},
+		DirectoryURL: directoryURL,
+		DirectoryCA:  os.Getenv("ACME_TEST_CA"),
+		CacheDir:     t.TempDir(),
+		HTTPAddress:  "127.0.0.1:5002",
+	}
+
+	m, err := NewACME(conf, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.serveHTTP01(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.renew(); err != nil {
+		t.Fatal(err)
+	}
+
+	issued, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issued.Leaf.DNSNames) != 1 || issued.Leaf.DNSNames[0] != "localhost" {
+		t.Fatal("Unexpected certificate:", issued.Leaf.DNSNames)
+	}
+
+	// The certificate is only renewed when it expires soon
+	if err = m.renew(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cert, _ := m.GetCertificate(nil); cert != issued {
+		t.Fatal("The certificate was renewed too early")
+	}
+
+	m.renewBefore = time.Until(issued.Leaf.NotAfter) + time.Hour
+
+	if err = m.renew(); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, _ := m.GetCertificate(nil)
+	if renewed.Leaf.SerialNumber.Cmp(issued.Leaf.SerialNumber) == 0 {
+		t.Fatal("The certificate wasn't renewed")
+	}
+
+	// The renewed certificate is loaded from the cache after a restart
+	restarted, err := NewACME(&confpar.ACME{Domains: conf.Domains, CacheDir: conf.CacheDir}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := restarted.certFiles()
+
+	cached, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.Leaf.SerialNumber.Cmp(renewed.Leaf.SerialNumber) != 0 {
+		t.Fatal("The renewed certificate wasn't cached")
+	}
+}
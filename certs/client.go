@@ -0,0 +1,99 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Client certificate modes
+const (
+	ClientCertRequired   = "required"   // The certificate is required in addition to the password
+	ClientCertSufficient = "sufficient" // The certificate authenticates the user without password
+)
+
+// ErrClientCertRequired is returned when a user didn't present a verified client certificate
+var ErrClientCertRequired = errors.New("client certificate required")
+
+// ErrClientCertMismatch is returned when the client certificate doesn't match the one expected from the user
+var ErrClientCertMismatch = errors.New("client certificate doesn't match")
+
+// ErrNoCACertificate is returned when the client_ca file doesn't contain any certificate
+var ErrNoCACertificate = errors.New("no certificate found in client_ca")
+
+// LoadCertPool loads the PEM certificates of a file
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	caBytes, err := os.ReadFile(file) //nolint:gosec // file of the config
+	if err != nil {
+		return nil, fmt.Errorf("could not load CA file: %s: %w", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, ErrNoCACertificate
+	}
+
+	return pool, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate in hex
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// HasConstraints tells if a certificate is identified by at least one constraint
+func HasConstraints(conf *confpar.ClientCert) bool {
+	return conf.CommonName != "" || len(conf.SANs) > 0 || len(conf.Fingerprints) > 0
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	return names
+}
+
+// MatchClientCert checks a verified client certificate against the one expected from a user
+func MatchClientCert(conf *confpar.ClientCert, cert *x509.Certificate) error {
+	if cert == nil {
+		return ErrClientCertRequired
+	}
+
+	if conf.CommonName != "" && conf.CommonName != cert.Subject.CommonName {
+		return ErrClientCertMismatch
+	}
+
+	if len(conf.SANs) > 0 {
+		names := subjectAltNames(cert)
+		if !slices.ContainsFunc(conf.SANs, func(san string) bool { return slices.Contains(names, san) }) {
+			return ErrClientCertMismatch
+		}
+	}
+
+	if len(conf.Fingerprints) > 0 {
+		fingerprint := Fingerprint(cert)
+		if !slices.ContainsFunc(conf.Fingerprints, func(expected string) bool {
+			return strings.EqualFold(strings.ReplaceAll(expected, ":", ""), fingerprint)
+		}) {
+			return ErrClientCertMismatch
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestMatchClientCert(t *testing.T) {
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		Subject:        pkix.Name{CommonName: "scanner1"},
+		EmailAddresses: []string{"scanner1@example.com"},
+	}
+
+	fingerprint := Fingerprint(cert)
+
+	for _, tc := range []struct {
+		conf *confpar.ClientCert
+		err  error
+	}{
+		{&confpar.ClientCert{}, nil},
+		{&confpar.ClientCert{CommonName: "scanner1"}, nil},
+		{&confpar.ClientCert{CommonName: "scanner2"}, ErrClientCertMismatch},
+		{&confpar.ClientCert{SANs: []string{"other.example.com", "scanner1@example.com"}}, nil},
+		{&confpar.ClientCert{SANs: []string{"scanner1"}}, ErrClientCertMismatch},
+		{&confpar.ClientCert{Fingerprints: []string{strings.ToUpper(fingerprint[:2] + ":" + fingerprint[2:])}}, nil},
+		{&confpar.ClientCert{CommonName: "scanner1", Fingerprints: []string{"00"}}, ErrClientCertMismatch},
+	} {
+		if err := MatchClientCert(tc.conf, cert); !errors.Is(err, tc.err) {
+			t.Errorf("Unexpected result for %+v: %v", tc.conf, err)
+		}
+	}
+
+	if err := MatchClientCert(&confpar.ClientCert{}, nil); !errors.Is(err, ErrClientCertRequired) {
+		t.Fatal("A certificate should be required:", err)
+	}
+}
@@ -0,0 +1,133 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+)
+
+// KeyPair serves a certificate loaded from files, which can be reloaded without restart
+type KeyPair struct {
+	logger   log.Logger
+	mu       sync.Mutex // Serializes the reloads
+	certFile string
+	keyFile  string
+	modTimes [2]time.Time // Modification times of the last loaded files
+	cert     atomic.Pointer[tls.Certificate]
+	stop     chan struct{}
+}
+
+// NewKeyPair loads a certificate and its private key
+func NewKeyPair(certFile, keyFile string, logger log.Logger) (*KeyPair, error) {
+	k := &KeyPair{logger: logger}
+
+	if err := k.Reload(certFile, keyFile); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func fileModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Reload loads the certificate files, the current certificate is kept if they can't be parsed
+func (k *KeyPair) Reload(certFile, keyFile string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.certFile, k.keyFile = certFile, keyFile
+
+	return k.load()
+}
+
+func (k *KeyPair) load() error {
+	k.modTimes = [2]time.Time{fileModTime(k.certFile), fileModTime(k.keyFile)}
+
+	certBytes, err := os.ReadFile(k.certFile)
+	if err != nil {
+		return fmt.Errorf("could not load cert file: %s: %w", k.certFile, err)
+	}
+
+	keyBytes, err := os.ReadFile(k.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load key file: %s: %w", k.keyFile, err)
+	}
+
+	cert, err := tls.X509KeyPair(certBytes, keyBytes)
+	if err != nil {
+		return fmt.Errorf("could not parse key pairs: %w", err)
+	}
+
+	if previous := k.cert.Swap(&cert); previous == nil || !previous.Leaf.Equal(cert.Leaf) {
+		k.logger.Info("Loaded certificate", "file", k.certFile, "subject", cert.Leaf.Subject.String(),
+			"expiry", cert.Leaf.NotAfter)
+	}
+
+	return nil
+}
+
+// GetCertificate returns the current certificate, it's meant to be used in a tls.Config
+func (k *KeyPair) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return k.cert.Load(), nil
+}
+
+// Watch reloads the certificate when its files are modified, they are checked at each interval until Stop is called
+func (k *KeyPair) Watch(interval time.Duration) {
+	k.Stop()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	stop := make(chan struct{})
+	k.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				k.check()
+			}
+		}
+	}()
+}
+
+// Stop stops watching the files
+func (k *KeyPair) Stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+func (k *KeyPair) check() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if fileModTime(k.certFile).Equal(k.modTimes[0]) && fileModTime(k.keyFile).Equal(k.modTimes[1]) {
+		return
+	}
+
+	if err := k.load(); err != nil {
+		k.logger.Error("Could not reload certificate, keeping the current one", "err", err)
+	}
+}
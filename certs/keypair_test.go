@@ -0,0 +1,141 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func writeKeyPair(t *testing.T, dir, name string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, k *KeyPair) string {
+	t.Helper()
+
+	cert, err := k.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestKeyPairReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeKeyPair(t, dir, "first")
+
+	k, err := NewKeyPair(certFile, keyFile, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeyPair(t, dir, "second")
+
+	if err := k.Reload(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := commonName(t, k); name != "second" {
+		t.Fatal("The certificate wasn't swapped:", name)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Reload(certFile, keyFile); err == nil {
+		t.Fatal("An invalid key pair should be refused")
+	}
+
+	if name := commonName(t, k); name != "second" {
+		t.Fatal("The previous certificate should be kept:", name)
+	}
+}
+
+func TestKeyPairsSelection(t *testing.T) {
+	var confs []*confpar.ServerCert
+
+	for _, name := range []string{"a.example", "b.example"} {
+		dir := t.TempDir()
+		writeKeyPair(t, dir, name)
+		confs = append(confs, &confpar.ServerCert{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")})
+	}
+
+	k, err := NewKeyPairs(confs, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for serverName, expected := range map[string]string{"b.example": "b.example", "c.example": "a.example", "": "a.example"} {
+		cert, err := k.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:        serverName,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cert.Leaf.Subject.CommonName != expected {
+			t.Errorf("Unexpected certificate for %q: %s", serverName, cert.Leaf.Subject.CommonName)
+		}
+	}
+
+	// An invalid certificate is skipped on reload
+	if err := k.Reload(append(confs, &confpar.ServerCert{Cert: "missing.pem", Key: "missing.key"})); err == nil {
+		t.Fatal("The missing certificate should be reported")
+	}
+
+	if len(k.pairs) != 2 {
+		t.Fatal("The valid certificates should be kept")
+	}
+}
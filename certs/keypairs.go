@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// KeyPairs serves the certificate matching the server name requested by each client among several key pairs
+type KeyPairs struct {
+	logger   log.Logger
+	mu       sync.RWMutex
+	pairs    []*KeyPair
+	interval time.Duration // Watch interval of the files
+}
+
+// NewKeyPairs loads the certificates, the first one is served to the clients not matching any other
+func NewKeyPairs(confs []*confpar.ServerCert, logger log.Logger) (*KeyPairs, error) {
+	k := &KeyPairs{logger: logger}
+
+	if err := k.Reload(confs); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload loads the certificates, the current certificate of a key pair is kept if its new files can't be parsed
+func (k *KeyPairs) Reload(confs []*confpar.ServerCert) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	current := make(map[[2]string]*KeyPair, len(k.pairs))
+	for _, pair := range k.pairs {
+		current[[2]string{pair.certFile, pair.keyFile}] = pair
+	}
+
+	pairs := make([]*KeyPair, 0, len(confs))
+
+	var errs []error
+
+	for _, conf := range confs {
+		files := [2]string{conf.Cert, conf.Key}
+
+		if pair := current[files]; pair != nil {
+			delete(current, files)
+
+			if err := pair.Reload(conf.Cert, conf.Key); err != nil {
+				errs = append(errs, err)
+			}
+
+			pairs = append(pairs, pair)
+
+			continue
+		}
+
+		pair, err := NewKeyPair(conf.Cert, conf.Key, k.logger)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if k.interval > 0 {
+			pair.Watch(k.interval)
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	// The startup fails on any invalid certificate, a reload only skips them
+	if len(pairs) == 0 || (k.pairs == nil && len(errs) > 0) {
+		return errors.Join(errs...)
+	}
+
+	for _, pair := range current {
+		pair.Stop()
+	}
+
+	k.pairs = pairs
+
+	return errors.Join(errs...)
+}
+
+// Watch reloads the certificates when their files are modified, they are checked at each interval.
+// A zero interval stops watching them.
+func (k *KeyPairs) Watch(interval time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.interval = interval
+
+	for _, pair := range k.pairs {
+		if interval > 0 {
+			pair.Watch(interval)
+		} else {
+			pair.Stop()
+		}
+	}
+}
+
+// GetCertificate returns the first certificate supported by the client, it's meant to be used in a tls.Config
+func (k *KeyPairs) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, pair := range k.pairs {
+		if cert := pair.cert.Load(); hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+
+	return k.pairs[0].cert.Load(), nil
+}
@@ -0,0 +1,86 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+// UnsupportedTLSOptionError is returned when a TLS version, cipher suite or curve isn't supported
+type UnsupportedTLSOptionError struct {
+	error
+	Option string
+	Value  string
+}
+
+func (err UnsupportedTLSOptionError) Error() string {
+	return fmt.Sprintf("Unsupported TLS %s: %s", err.Option, err.Value)
+}
+
+func cipherSuiteIDs() map[string]uint16 {
+	ids := make(map[string]uint16)
+
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			ids[suite.Name] = suite.ID
+		}
+	}
+
+	return ids
+}
+
+// ApplyPolicy sets the minimum version, the cipher suites and the curve preferences of a TLS config,
+// the Go defaults are kept for the unspecified ones
+func ApplyPolicy(tlsConfig *tls.Config, conf *confpar.TLS) error {
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	if conf.MinVersion != "" {
+		version, ok := tlsVersions[conf.MinVersion]
+		if !ok {
+			return &UnsupportedTLSOptionError{Option: "version", Value: conf.MinVersion}
+		}
+
+		tlsConfig.MinVersion = version
+	}
+
+	if len(conf.CipherSuites) > 0 {
+		ids := cipherSuiteIDs()
+
+		for _, name := range conf.CipherSuites {
+			id, ok := ids[strings.ToUpper(name)]
+			if !ok {
+				return &UnsupportedTLSOptionError{Option: "cipher suite", Value: name}
+			}
+
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+
+	for _, name := range conf.CurvePreferences {
+		curve, ok := curves[name]
+		if !ok {
+			return &UnsupportedTLSOptionError{Option: "curve", Value: name}
+		}
+
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, curve)
+	}
+
+	return nil
+}
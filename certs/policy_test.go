@@ -0,0 +1,31 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	tlsConfig := &tls.Config{} //nolint:gosec // set by ApplyPolicy
+
+	if err := ApplyPolicy(tlsConfig, &confpar.TLS{
+		MinVersion:       "1.3",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		CurvePreferences: []string{"X25519"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig.MinVersion != tls.VersionTLS13 || tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ||
+		tlsConfig.CurvePreferences[0] != tls.X25519 {
+		t.Fatal("Unexpected config:", tlsConfig)
+	}
+
+	var errOption *UnsupportedTLSOptionError
+	if err := ApplyPolicy(&tls.Config{}, &confpar.TLS{CurvePreferences: []string{"P128"}}); !errors.As(err, &errOption) { //nolint:gosec
+		t.Fatal("The curve should be rejected:", err)
+	}
+}
@@ -2,30 +2,46 @@
 package config
 
 import (
-	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 
 	log "github.com/fclairamb/go-log"
 
+	"github.com/fclairamb/ftpserver/certs"
 	"github.com/fclairamb/ftpserver/config/confpar"
 	"github.com/fclairamb/ftpserver/fs"
+	"github.com/fclairamb/ftpserver/ipfilter"
 
 	"github.com/go-crypt/crypt"
-	"github.com/go-crypt/crypt/algorithm/bcrypt"
 	"github.com/tidwall/sjson"
 )
 
 // ErrUnknownUser is returned when the provided user cannot be identified through our authentication mechanism
 var ErrUnknownUser = errors.New("unknown user")
 
+// ErrInvalidPassword is returned when the user is known but none of its passwords match
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrACMEWithServerCerts is returned when the certificates are both obtained by ACME and loaded from files
+var ErrACMEWithServerCerts = errors.New("tls.acme can't be combined with tls.server_cert or tls.server_certs")
+
 // Config provides the general server config
 type Config struct {
-	fileName string
-	logger   log.Logger
-	Content  *confpar.Content
+	fileName     string
+	logger       log.Logger
+	Content      *confpar.Content
+	userPatterns map[string]*regexp.Regexp // Compiled users of the templated accesses
+	mu           sync.RWMutex              // Protects the content from the password hash upgrades
+	loaded       bool                      // The content was loaded from the file, which can be rewritten
+	targetHash   string                    // Parameters of the configured password hash
+	logins       *loginCounter             // Logins of the accesses having a max_logins
+	totp         *totpVerifier             // Codes of the accesses having a TOTP secret
 }
 
 // NewConfig creates a new config instance
@@ -86,7 +102,11 @@ func (c *Config) Load() error {
 		return errDecode
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.Content = &content
+	c.loaded = true
 
 	if c.Content.HashPlaintextPasswords {
 		c.HashPlaintextPasswords()
@@ -95,6 +115,8 @@ func (c *Config) Load() error {
 	return c.Prepare()
 }
 
+// HashPlaintextPasswords replaces the plain-text passwords by hashes of the configured algorithm, in memory and in
+// the config file
 func (c *Config) HashPlaintextPasswords() error {
 	json, errReadFile := os.ReadFile(c.fileName)
 	if errReadFile != nil {
@@ -102,55 +124,31 @@ func (c *Config) HashPlaintextPasswords() error {
 		return errReadFile
 	}
 
+	hasher, err := newHasher(c.Content.PasswordHashing)
+	if err != nil {
+		return err
+	}
+
 	save := false
 	for i, a := range c.Content.Accesses {
 		if a.User == "anonymous" && a.Pass == "*" {
 			continue
 		}
 
-		switch true {
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$1$")):
-			//This user's password is md5crypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2$")):
-			//This user's password is bcrypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2a$")):
-			//This user's password is bcrypt-a
+		if isHashed(a.Pass) {
 			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2b$")):
-			//This user's password is bcrypt-b
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2x$")):
-			//This user's password is bcrypt-x
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$2y$")):
-			//This user's password is bcrypt-y
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$5$")):
-			//This user's password is sha256crypt
-			continue
-		case bytes.HasPrefix([]byte(a.Pass), []byte("$6$")):
-			//This user's password is sha512crypt
-			continue
-		default:
-			//This password is not hashed
-			hasher, err := bcrypt.New(bcrypt.WithCost(10))
-			if err != nil {
-				return err
-			}
+		}
 
-			digest, err := hasher.Hash(a.Pass)
-			if err != nil {
-				return err
-			}
+		digest, err := hasher.Hash(a.Pass)
+		if err != nil {
+			return err
+		}
 
-			modified, errJsonSet := sjson.Set(string(json), "accesses."+fmt.Sprint(i)+".pass", digest.Encode())
-			c.Content.Accesses[i].Pass = digest.Encode()
-			if errJsonSet == nil {
-				save = true
-				json = []byte(modified)
-			}
+		modified, errJsonSet := sjson.Set(string(json), "accesses."+fmt.Sprint(i)+".pass", digest.Encode())
+		c.Content.Accesses[i].Pass = digest.Encode()
+		if errJsonSet == nil {
+			save = true
+			json = []byte(modified)
 		}
 	}
 	if save {
@@ -174,13 +172,96 @@ func (c *Config) Prepare() error {
 		ct.PublicHost = publicHost
 	}
 
+	if _, err := ipfilter.NewFilter(ct.AllowedIPs, ct.DeniedIPs); err != nil {
+		return err
+	}
+
+	if tlsConf := ct.TLS; tlsConf != nil && tlsConf.ACME != nil &&
+		(tlsConf.ServerCert != nil || len(tlsConf.ServerCerts) > 0) {
+		return ErrACMEWithServerCerts
+	}
+
+	c.targetHash = ""
+
+	if ct.PasswordHashing != nil {
+		targetHash, err := targetHashParams(ct.PasswordHashing)
+		if err != nil {
+			return err
+		}
+
+		c.targetHash = targetHash
+	}
+
+	userPatterns := make(map[string]*regexp.Regexp)
+
+	for _, access := range ct.Accesses {
+		if _, err := ipfilter.NewFilter(access.AllowedIPs, access.DeniedIPs); err != nil {
+			return fmt.Errorf("invalid IPs of %s: %w", access.User, err)
+		}
+
+		if access.TOTPSecret != "" {
+			if _, err := decodeTOTPSecret(access.TOTPSecret); err != nil {
+				return fmt.Errorf("invalid TOTP secret of %s: %w", access.User, err)
+			}
+		}
+
+		if !isUserPattern(access.User) {
+			continue
+		}
+
+		pattern, err := compileUserPattern(access.User)
+		if err != nil {
+			return fmt.Errorf("invalid user pattern %s: %w", access.User, err)
+		}
+
+		userPatterns[access.User] = pattern
+	}
+
+	c.userPatterns = userPatterns
+
+	if c.logins == nil {
+		c.logins = newLoginCounter(c.logger)
+	}
+
+	if c.totp == nil {
+		c.totp = newTOTPVerifier()
+	}
+
+	c.logins.setFile(ct.LoginsFile)
+
 	return nil
 }
 
+// isUserPattern tells if the user of an access matches multiple users:
+// either a regular expression starting with "^" or a wildcard using "*" and "?"
+func isUserPattern(user string) bool {
+	return strings.HasPrefix(user, "^") || strings.ContainsAny(user, "*?")
+}
+
+func compileUserPattern(user string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(user, "^") {
+		return regexp.Compile(user)
+	}
+
+	expr := regexp.QuoteMeta(user)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+
+	return regexp.Compile("^" + expr + "$")
+}
+
+func (c *Config) matchUser(access *confpar.Access, user string) bool {
+	if pattern := c.userPatterns[access.User]; pattern != nil {
+		return pattern.MatchString(user)
+	}
+
+	return access.User == user
+}
+
 // CheckAccesses checks all accesses
 func (c *Config) CheckAccesses() error {
 	for _, access := range c.Content.Accesses {
-		_, errAccess := fs.LoadFs(access, c.logger)
+		_, errAccess := fs.LoadFs(access, c.logger, nil)
 		if errAccess != nil {
 			c.logger.Error("Config: Invalid access !", "err", errAccess, "username", access.User, "fs", access.Fs)
 
@@ -191,144 +272,147 @@ func (c *Config) CheckAccesses() error {
 	return nil
 }
 
-// GetAccess return a file system access given some credentials
-func (c *Config) GetAccess(user string, pass string) (*confpar.Access, error) {
+// GetCertAccess returns the access of a user authenticated by a verified client certificate, only the accesses
+// identifying the certificate and accepting it without password are considered.
+func (c *Config) GetCertAccess(user string, cert *x509.Certificate, remoteIP string) (*confpar.Access, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, a := range c.Content.Accesses {
+		if !c.matchUser(a, user) || a.ClientCert == nil || a.ClientCert.Mode != certs.ClientCertSufficient ||
+			!certs.HasConstraints(a.ClientCert) {
+			continue
+		}
+
+		if certs.MatchClientCert(a.ClientCert, cert) == nil && c.logins.check(user, a) == nil {
+			return a.Instantiate(user, map[string]string{"remote_ip": remoteIP}), nil
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// CheckAccess checks the validity period of an access, whatever its source, and that the user didn't reach its
+// max_logins
+func (c *Config) CheckAccess(user string, access *confpar.Access) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.logins.check(user, access)
+}
+
+// UseAccess checks an access like CheckAccess, counts the login of the user against its max_logins and records its
+// TOTP code as used. It's called once the session of the user is accepted.
+func (c *Config) UseAccess(user string, access *confpar.Access) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if access.TOTPPeriod != 0 {
+		if err := c.totp.use(user, access.TOTPPeriod); err != nil {
+			return err
+		}
+	}
+
+	return c.logins.use(user, access)
+}
+
+// GetAccess return a file system access given some credentials.
+// The {user} and {remote_ip} placeholders of the access params are replaced by their values.
+// The accesses outside of their validity period or having reached their max_logins are skipped, the login is only
+// counted by UseAccess.
+// The accesses having a TOTP secret expect the current code to be appended to the password: "password:123456".
+// A password hashed differently than configured is upgraded once it matched, if the rehash is enabled.
+func (c *Config) GetAccess(user string, pass string, remoteIP string) (*confpar.Access, error) {
 	decoder, err := crypt.NewDecoderAll()
 	if err != nil {
 		return nil, err
 	}
 
+	access, outdated, err := c.matchAccess(decoder, user, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	if outdated != nil {
+		if outdated.TOTPSecret != "" {
+			pass, _ = splitTOTPCode(pass)
+		}
+
+		if errRehash := c.rehash(outdated, pass); errRehash != nil {
+			c.logger.Warn("Could not upgrade password hash", "user", user, "err", errRehash)
+		}
+	}
+
+	return access.Instantiate(user, map[string]string{"remote_ip": remoteIP}), nil
+}
+
+// matchAccess returns a copy of the first valid access accepting the credentials, and the access itself if its
+// password hash should be upgraded
+func (c *Config) matchAccess(decoder *crypt.Decoder, user, pass string) (*confpar.Access, *confpar.Access, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	found := false
+	errRejected := ErrInvalidPassword
+
 	for _, a := range c.Content.Accesses {
-		if a.User == user {
-			switch true {
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$1$")):
-				//This user's password is md5crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2$")):
-				//This user's password is bcrypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2a$")):
-				//This user's password is bcrypt-a
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2b$")):
-				//This user's password is bcrypt-b
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2x$")):
-				//This user's password is bcrypt-x
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$2y$")):
-				//This user's password is bcrypt-y
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$5$")):
-				//This user's password is sha256crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			case bytes.HasPrefix([]byte(a.Pass), []byte("$6$")):
-				//This user's password is sha512crypt
-				digest, err := decoder.Decode(a.Pass)
-				if err != nil {
-					return nil, err
-				}
-
-				ok, err := digest.MatchAdvanced(pass)
-				if err != nil {
-					return nil, err
-				}
-
-				if ok {
-					return a, nil
-				}
-			default:
-				//This user's password is plain-text
-				if a.Pass == pass || (a.User == "anonymous" && a.Pass == "*") {
-					return a, nil
-				}
+		if !c.matchUser(a, user) {
+			continue
+		}
+
+		found = true
+
+		password, code := pass, ""
+		if a.TOTPSecret != "" {
+			password, code = splitTOTPCode(pass)
+		}
+
+		ok := a.User == "anonymous" && a.Pass == "*"
+		if !ok {
+			var err error
+			if ok, err = matchPassword(decoder, a.Pass, password); err != nil {
+				return nil, nil, err
 			}
 		}
+
+		if !ok {
+			continue
+		}
+
+		// An expired access doesn't prevent the next ones from matching, nor uses the TOTP code
+		if err := c.logins.check(user, a); err != nil {
+			errRejected = err
+
+			continue
+		}
+
+		// The client can't tell a wrong TOTP code from a wrong password
+		var period uint64
+
+		if a.TOTPSecret != "" {
+			var err error
+			if period, err = c.totp.verify(user, a.TOTPSecret, code); err != nil {
+				c.logger.Warn("TOTP code rejected", "user", user, "err", err)
+
+				errRejected = ErrInvalidPassword
+
+				continue
+			}
+		}
+
+		access := *a
+		access.TOTPPeriod = period
+
+		if c.needsRehash(a.Pass) {
+			return &access, a, nil
+		}
+
+		return &access, nil, nil
 	}
 
-	return nil, ErrUnknownUser
+	if found {
+		return nil, nil, errRejected
+	}
+
+	return nil, nil, ErrUnknownUser
 }
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestACMEWithServerCerts(t *testing.T) {
+	content := &confpar.Content{
+		TLS: &confpar.TLS{
+			ACME:        &confpar.ACME{Domains: []string{"ftp.example.com"}},
+			ServerCerts: []*confpar.ServerCert{{Cert: "cert.pem", Key: "key.pem"}},
+		},
+	}
+
+	if _, err := FromContent(content, "", lognoop.NewNoOpLogger()); !errors.Is(err, ErrACMEWithServerCerts) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	content.TLS.ServerCerts = nil
+
+	if _, err := FromContent(content, "", lognoop.NewNoOpLogger()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,11 @@
 // Package confpar provide the core parameters of the config
 package confpar
 
-import "time"
+import (
+	"time"
+
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
 
 // Access provides rules around any access
 type Access struct {
@@ -13,13 +17,143 @@ type Access struct {
 	ReadOnly      bool              `json:"read_only"`       // Read-only access
 	Shared        bool              `json:"shared"`          // Shared FS instance
 	SyncAndDelete *SyncAndDelete    `json:"sync_and_delete"` // Local empty directory and synchronization
+	MaxSessions   int               `json:"max_sessions"`    // Maximum concurrent sessions for this access
+	Permissions   []*Permission     `json:"permissions"`     // Per-path permissions, first match wins
+	OnUpload      []string          `json:"on_upload"`       // Command run after each upload, with placeholders
+	AtomicUploads bool              `json:"atomic_uploads"`  // Upload to a temporary file renamed once complete
+	Quota         *Quota            `json:"quota"`           // Storage quota
+	Bandwidth     *Bandwidth        `json:"bandwidth"`       // Transfer rates shared by all the sessions
+	ClientCert    *ClientCert       `json:"client_cert"`     // TLS client certificate expected from the user
+	TLSRequired   bool              `json:"tls_required"`    // Login refused on cleartext control connections
+	AllowedIPs    []string          `json:"allowed_ips"`     // CIDRs the user can log in from, any if empty
+	DeniedIPs     []string          `json:"denied_ips"`      // CIDRs the user can't log in from
+	ValidFrom     *time.Time        `json:"valid_from"`      // Time from which the access can be used (RFC 3339)
+	ValidUntil    *time.Time        `json:"valid_until"`     // Time from which the access can't be used anymore
+	MaxLogins     int               `json:"max_logins"`      // Maximum logins of each user, unlimited if 0
+	TOTPSecret    string            `json:"totp_secret"`     // Base32 TOTP secret, the code is appended to the password
+	Template      string            `json:"-"`               // Configured access or template this access comes from
+	TOTPPeriod    uint64            `json:"-"`               // Period of the TOTP code used to log in
+}
+
+// ClientCert defines the TLS client certificate expected from a user, every specified constraint must match
+type ClientCert struct {
+	Mode         string   `json:"mode"`         // required (with the password, default) or sufficient (without)
+	CommonName   string   `json:"common_name"`  // Subject common name
+	SANs         []string `json:"sans"`         // DNS names, emails, IPs or URIs, one of them must be present
+	Fingerprints []string `json:"fingerprints"` // SHA-256 fingerprints in hex, one of them must match
+}
+
+// Bandwidth defines transfer rates in bytes per second, 0 means unlimited
+type Bandwidth struct {
+	Upload   int64 `json:"upload"`   // Upload rate
+	Download int64 `json:"download"` // Download rate
+}
+
+// Quota defines the storage limits of an access, 0 means unlimited
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"` // Maximum bytes stored
+	MaxFiles int64 `json:"max_files"` // Maximum number of files
+}
+
+// Permission defines the operations allowed on the paths matching a glob
+type Permission struct {
+	Path  string   `json:"path"`  // Glob of the paths, "**" matches any number of directories
+	Allow []string `json:"allow"` // list, download, upload, overwrite, rename, delete and/or mkdir
+}
+
+// Instantiate creates the access of a user from this access used as a template. The {user} placeholder
+// and the provided values are replaced in the params, after being sanitized.
+func (a *Access) Instantiate(user string, values map[string]string) *Access {
+	placeholders := make(map[string]string, len(values)+1)
+	for key, value := range values {
+		placeholders[key] = value
+	}
+
+	placeholders["user"] = user
+
+	access := *a
+	access.User = user
+
+	if access.Template == "" {
+		access.Template = a.User
+	}
+	access.Pass = ""
+	access.Params = make(map[string]string, len(a.Params))
+
+	for key, value := range a.Params {
+		access.Params[key] = utils.ReplacePlaceholders(value, placeholders)
+	}
+
+	return &access
 }
 
 // AccessesWebhook defines an optional webhook to get user's access
 type AccessesWebhook struct {
-	URL     string            `json:"url"`     // URL to call
-	Headers map[string]string `json:"headers"` // Token to use in the
-	Timeout time.Duration     `json:"timeout"` // Max time request can take
+	URL              string            `json:"url"`                // URL to call
+	Headers          map[string]string `json:"headers"`            // Token to use in the
+	Timeout          time.Duration     `json:"timeout"`            // Max time request can take
+	CacheTTL         time.Duration     `json:"cache_ttl"`          // Time the accepted credentials are cached
+	NegativeCacheTTL time.Duration     `json:"negative_cache_ttl"` // Time the rejected credentials are cached
+	StaleTTL         time.Duration     `json:"stale_ttl"`          // Time an expired access is used if the webhook fails
+	FallbackURLs     []string          `json:"fallback_urls"`      // URLs called in order when the previous ones fail
+	MaxRetries       int               `json:"max_retries"`        // Retries of all the URLs when they all failed
+	RetryDelay       time.Duration     `json:"retry_delay"`        // Delay before the first retry, doubled by each new one
+	Secret           string            `json:"secret"`             // Key used to sign the requests with HMAC-SHA256
+}
+
+// EventsWebhook defines an optional webhook notified of file operations
+type EventsWebhook struct {
+	URL        string            `json:"url"`         // URL to call
+	Headers    map[string]string `json:"headers"`     // Headers to add to the requests
+	Secret     string            `json:"secret"`      // Key used to sign the payloads with HMAC-SHA256
+	Events     []string          `json:"events"`      // Events to send: upload, download, delete, rename, mkdir. All if empty
+	Timeout    time.Duration     `json:"timeout"`     // Max time a request can take
+	MaxRetries int               `json:"max_retries"` // Retries before an event is dropped
+	QueueDir   string            `json:"queue_dir"`   // Directory persisting the pending events
+	QueueSize  int               `json:"queue_size"`  // Maximum number of pending events
+}
+
+// Hooks defines how the hook commands are run
+type Hooks struct {
+	Timeout       time.Duration `json:"timeout"`        // Max time a command can take
+	MaxConcurrent int           `json:"max_concurrent"` // Maximum commands running at the same time
+}
+
+// Authenticator defines a link of the authentication chain
+type Authenticator struct {
+	Type   string  `json:"type"`   // static, webhook, ldap, htpasswd or sql
+	Name   string  `json:"name"`   // Name used in logs, defaults to the type
+	File   string  `json:"file"`   // htpasswd file
+	Access *Access `json:"access"` // Access template of the htpasswd users, params can use {user}
+}
+
+// SQL defines how users are authenticated against a SQL database
+type SQL struct {
+	Driver  string        `json:"driver"`  // sqlite (default), postgres or mysql
+	DSN     string        `json:"dsn"`     // Data source name, the file of a SQLite database
+	Table   string        `json:"table"`   // Table of the users, defaults to ftp_users
+	Query   string        `json:"query"`   // Query selecting the columns of a user, replaces the table
+	Timeout time.Duration `json:"timeout"` // Max time a query can take
+}
+
+// LDAP defines how users are authenticated against an LDAP directory
+type LDAP struct {
+	URL                string        `json:"url"`                  // Server URL (ldap:// or ldaps://)
+	StartTLS           bool          `json:"start_tls"`            // Upgrade the connection with StartTLS
+	InsecureSkipVerify bool          `json:"insecure_skip_verify"` // Don't check the server certificate
+	BindDN             string        `json:"bind_dn"`              // Service account used to search users
+	BindPassword       string        `json:"bind_password"`        // Service account password
+	BaseDN             string        `json:"base_dn"`              // Base of the users search
+	UserFilter         string        `json:"user_filter"`          // Users search filter, {user} is replaced
+	GroupAttribute     string        `json:"group_attribute"`      // Attribute listing the groups of a user
+	Timeout            time.Duration `json:"timeout"`              // Max time a request can take
+	Groups             []*LDAPGroup  `json:"groups"`               // Groups to accesses mapping, first match wins
+}
+
+// LDAPGroup maps an LDAP group to an access template
+type LDAPGroup struct {
+	Group  string  `json:"group"`  // Group DN or name, "*" matches any user
+	Access *Access `json:"access"` // Access template, params can use {user} and {<attribute>} placeholders
 }
 
 // SyncAndDelete provides
@@ -44,7 +178,49 @@ type Logging struct {
 
 // TLS define the TLS Config
 type TLS struct {
-	ServerCert *ServerCert `json:"server_cert"` // Server certificates
+	ServerCert       *ServerCert   `json:"server_cert"`       // Server certificates
+	ServerCerts      []*ServerCert `json:"server_certs"`      // Additional certificates, selected by server name (SNI)
+	ACME             *ACME         `json:"acme"`              // Certificates obtained from an ACME server
+	WatchInterval    time.Duration `json:"watch_interval"`    // Interval at which the certificate files are checked for changes
+	ClientCA         string        `json:"client_ca"`         // CA certificates verifying the client certificates
+	MinVersion       string        `json:"min_version"`       // Minimum TLS version: 1.0, 1.1, 1.2 (default) or 1.3
+	CipherSuites     []string      `json:"cipher_suites"`     // Cipher suites of TLS 1.2 and below, Go defaults if empty
+	CurvePreferences []string      `json:"curve_preferences"` // X25519, P256, P384, P521 or X25519MLKEM768, by preference
+}
+
+// ACME defines how certificates are obtained from an ACME server like Let's Encrypt
+type ACME struct {
+	Domains      []string      `json:"domains"`       // Domains of the certificate
+	Email        string        `json:"email"`         // Contact email of the account
+	DirectoryURL string        `json:"directory_url"` // ACME directory, defaults to Let's Encrypt
+	DirectoryCA  string        `json:"directory_ca"`  // Additional CA trusted for the directory (testing servers)
+	CacheDir     string        `json:"cache_dir"`     // Directory storing the account key and the certificates
+	Challenge    string        `json:"challenge"`     // http-01 (default) or dns-01
+	HTTPAddress  string        `json:"http_address"`  // Address serving the http-01 challenges, defaults to :80
+	DNSHook      []string      `json:"dns_hook"`      // Command called with present|cleanup, record name and value
+	RenewBefore  time.Duration `json:"renew_before"`  // Renewal delay before expiry, defaults to 30 days
+}
+
+// BruteForce defines the protection against password guessing. Failures are counted per IP and per user,
+// reaching max_failures bans them.
+type BruteForce struct {
+	MaxFailures    int           `json:"max_failures"`     // Failures before a ban, defaults to 5
+	Window         time.Duration `json:"window"`           // Period the failures are counted over, defaults to 10 minutes
+	BanDuration    time.Duration `json:"ban_duration"`     // First ban duration, doubled by each new ban, defaults to 15 minutes
+	MaxBanDuration time.Duration `json:"max_ban_duration"` // Maximum ban duration, defaults to 24 hours
+	Delay          time.Duration `json:"delay"`            // Reply delay of a failure, doubled by each new one, defaults to 1s
+	MaxDelay       time.Duration `json:"max_delay"`        // Maximum reply delay, defaults to 30s
+	BanFile        string        `json:"ban_file"`         // File persisting the bans
+	NeverBan       []string      `json:"never_ban"`        // CIDRs never delayed nor banned
+}
+
+// PasswordHashing defines how the passwords of the accesses are hashed
+type PasswordHashing struct {
+	Algorithm   string `json:"algorithm"`   // bcrypt (default), argon2id or scrypt
+	Cost        int    `json:"cost"`        // bcrypt cost (10), argon2id iterations (3) or scrypt log2(N) (16)
+	Memory      int    `json:"memory"`      // argon2id memory in KiB (65536)
+	Parallelism int    `json:"parallelism"` // argon2id (4) or scrypt (1) parallelism
+	Rehash      bool   `json:"rehash"`      // Upgrade the other hashes to this one at the next successful login
 }
 
 // ServerCert defines the TLS server certificate config
@@ -53,17 +229,45 @@ type ServerCert struct {
 	Key  string `json:"key"`  // Private key
 }
 
+// Metrics defines the Prometheus metrics endpoint
+type Metrics struct {
+	ListenAddress string `json:"listen_address"` // Address to listen on
+	Path          string `json:"path"`           // Path of the metrics, defaults to /metrics
+}
+
+// AdminAPI defines the admin HTTP API
+type AdminAPI struct {
+	ListenAddress string `json:"listen_address"` // Address to listen on
+	Token         string `json:"token"`          // Bearer token required on each request
+}
+
 // Content defines the content of the config file
 type Content struct {
 	Version                  int              `json:"version"`                     // File format version
 	ListenAddress            string           `json:"listen_address"`              // Address to listen on
 	PublicHost               string           `json:"public_host"`                 // Public host to listen on
 	MaxClients               int              `json:"max_clients"`                 // Maximum clients who can connect
+	MaxSessionsPerIP         int              `json:"max_sessions_per_ip"`         // Maximum clients per remote IP
 	HashPlaintextPasswords   bool             `json:"hash_plaintext_passwords"`    // Overwrite plain-text passwords with hashed equivalents
+	PasswordHashing          *PasswordHashing `json:"password_hashing"`            // Algorithm of the hashed passwords
 	Accesses                 []*Access        `json:"accesses"`                    // Accesses offered to users
 	PassiveTransferPortRange *PortRange       `json:"passive_transfer_port_range"` // Listen port range
 	Logging                  Logging          `json:"logging"`                     // Logging parameters
 	TLS                      *TLS             `json:"tls"`                         // TLS Config
   TLSRequired              string           `json:"tls_required"`
 	AccessesWebhook          *AccessesWebhook `json:"accesses_webhook"`            // Webhook to call when accesses are updated
+	EventsWebhook            *EventsWebhook   `json:"events_webhook"`              // Webhook notified of file operations
+	Hooks                    *Hooks           `json:"hooks"`                       // Hook commands settings
+	QuotaDir                 string           `json:"quota_dir"`                   // Directory persisting the quota usages
+	LoginsFile               string           `json:"logins_file"`                 // File persisting the counts of max_logins
+	BruteForce               *BruteForce      `json:"brute_force"`                 // Protection against password guessing
+	AllowedIPs               []string         `json:"allowed_ips"`                 // CIDRs allowed to connect, any if empty
+	DeniedIPs                []string         `json:"denied_ips"`                  // CIDRs rejected before the banner
+	Bandwidth                *Bandwidth       `json:"bandwidth"`                   // Transfer rates of the whole server
+	BandwidthPerIP           *Bandwidth       `json:"bandwidth_per_ip"`            // Transfer rates of each remote IP
+	LDAP                     *LDAP            `json:"ldap"`                        // LDAP directory to authenticate users
+	SQL                      *SQL             `json:"sql"`                         // SQL database to authenticate users
+	Authenticators           []*Authenticator `json:"authenticators"`              // Authentication chain
+	Metrics                  *Metrics         `json:"metrics"`                     // Prometheus metrics endpoint
+	AdminAPI                 *AdminAPI        `json:"admin_api"`                   // Admin HTTP API
 }
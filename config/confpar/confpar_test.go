@@ -0,0 +1,21 @@
+package confpar
+
+import "testing"
+
+func TestInstantiate(t *testing.T) {
+	template := &Access{
+		User:   "cam-*",
+		Pass:   "secret",
+		Fs:     "os",
+		Params: map[string]string{"basePath": "/data/{user}/{remote_ip}"},
+	}
+	access := template.Instantiate("../cam1", map[string]string{"remote_ip": "10.0.0.1"})
+
+	if access.User != "../cam1" || access.Pass != "" || access.Params["basePath"] != "/data/.._cam1/10.0.0.1" {
+		t.Error("Wrong access", access)
+	}
+
+	if template.Params["basePath"] != "/data/{user}/{remote_ip}" {
+		t.Error("Template was modified")
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// ErrAccessNotYetValid is returned when an access is used before its valid_from time
+var ErrAccessNotYetValid = errors.New("access not yet valid")
+
+// ErrAccessExpired is returned when an access is used after its valid_until time
+var ErrAccessExpired = errors.New("access expired")
+
+// ErrMaxLoginsReached is returned when an access was already used max_logins times
+var ErrMaxLoginsReached = errors.New("maximum logins reached")
+
+// loginCounter counts the logins of the users whose access has a max_logins
+type loginCounter struct {
+	logger log.Logger
+	mu     sync.Mutex
+	file   string         // File persisting the counts, optional
+	counts map[string]int // Logins per user
+	now    func() time.Time
+}
+
+func newLoginCounter(logger log.Logger) *loginCounter {
+	return &loginCounter{
+		logger: logger,
+		counts: make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+// setFile changes the file persisting the counts, its counts are loaded if it wasn't used yet
+func (l *loginCounter) setFile(file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if file == l.file {
+		return
+	}
+
+	l.file = file
+
+	if file != "" {
+		l.load()
+	}
+}
+
+// check checks the validity period of an access and that the user didn't reach its max_logins
+func (l *loginCounter) check(user string, access *confpar.Access) error {
+	return l.count(user, access, false)
+}
+
+// use checks the access like check, and counts the login of the user
+func (l *loginCounter) use(user string, access *confpar.Access) error {
+	return l.count(user, access, true)
+}
+
+func (l *loginCounter) count(user string, access *confpar.Access, increment bool) error {
+	now := l.now()
+
+	if access.ValidFrom != nil && now.Before(*access.ValidFrom) {
+		return ErrAccessNotYetValid
+	}
+
+	if access.ValidUntil != nil && !now.Before(*access.ValidUntil) {
+		return ErrAccessExpired
+	}
+
+	if access.MaxLogins <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[user] >= access.MaxLogins {
+		return ErrMaxLoginsReached
+	}
+
+	if increment {
+		l.counts[user]++
+		l.save()
+	}
+
+	return nil
+}
+
+// load restores the counts of the file, it must be called with the lock held
+func (l *loginCounter) load() {
+	data, err := os.ReadFile(l.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.logger.Warn("Could not read logins file", "file", l.file, "err", err)
+		}
+
+		return
+	}
+
+	var counts map[string]int
+	if err := json.Unmarshal(data, &counts); err != nil {
+		l.logger.Warn("Invalid logins file", "file", l.file, "err", err)
+
+		return
+	}
+
+	for user, count := range counts {
+		l.counts[user] = max(l.counts[user], count)
+	}
+}
+
+// save persists the counts, it must be called with the lock held
+func (l *loginCounter) save() {
+	if l.file == "" {
+		return
+	}
+
+	data, err := json.Marshal(l.counts)
+	if err != nil {
+		return
+	}
+
+	temp := l.file + ".tmp"
+
+	if err = os.MkdirAll(filepath.Dir(l.file), 0o750); err == nil {
+		if err = os.WriteFile(temp, data, 0o600); err == nil {
+			err = os.Rename(temp, l.file)
+		}
+	}
+
+	if err != nil {
+		l.logger.Warn("Could not save logins file", "file", l.file, "err", err)
+	}
+}
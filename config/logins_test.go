@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestAccessValidity(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	content := &confpar.Content{
+		LoginsFile: filepath.Join(t.TempDir(), "logins.json"),
+		Accesses: []*confpar.Access{
+			{User: "expired", Pass: "pass", ValidUntil: &past},
+			{User: "later", Pass: "pass", ValidFrom: &future},
+			{User: "contractor", Pass: "pass", ValidFrom: &past, ValidUntil: &future, MaxLogins: 2},
+		},
+	}
+
+	conf, err := FromContent(content, "", lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = conf.GetAccess("expired", "pass", ""); !errors.Is(err, ErrAccessExpired) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err = conf.GetAccess("later", "pass", ""); !errors.Is(err, ErrAccessNotYetValid) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err = conf.GetAccess("contractor", "wrong", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The login is only counted once the session is accepted
+	for range 3 {
+		if _, err = conf.GetAccess("contractor", "pass", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 2 {
+		access, errAccess := conf.GetAccess("contractor", "pass", "")
+		if errAccess != nil {
+			t.Fatal(errAccess)
+		}
+
+		if err = conf.UseAccess("contractor", access); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = conf.GetAccess("contractor", "pass", ""); !errors.Is(err, ErrMaxLoginsReached) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The counts are restored from the logins file
+	conf, err = FromContent(content, "", lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = conf.GetAccess("contractor", "pass", ""); !errors.Is(err, ErrMaxLoginsReached) {
+		t.Fatal("Unexpected error:", err)
+	}
+}
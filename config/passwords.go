@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-crypt/crypt"
+	"github.com/go-crypt/crypt/algorithm"
+	"github.com/go-crypt/crypt/algorithm/argon2"
+	"github.com/go-crypt/crypt/algorithm/bcrypt"
+	"github.com/go-crypt/crypt/algorithm/scrypt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+	HashScrypt   = "scrypt"
+)
+
+// Default hashing parameters
+const (
+	defaultBcryptCost        = 10
+	defaultArgon2Iterations  = 3
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Parallelism = 4
+	defaultScryptLN          = 16
+	defaultScryptParallelism = 1
+)
+
+// hashPrefixes are the prefixes of the hashed passwords:
+// md5crypt, bcrypt, sha256crypt, sha512crypt, argon2 and scrypt
+var hashPrefixes = []string{
+	"$1$", "$2$", "$2a$", "$2b$", "$2x$", "$2y$", "$5$", "$6$", "$argon2id$", "$argon2i$", "$argon2d$", "$scrypt$",
+}
+
+// UnsupportedHashAlgorithmError is returned when the password hashing algorithm isn't supported
+type UnsupportedHashAlgorithmError struct {
+	error
+	Algorithm string
+}
+
+func (e UnsupportedHashAlgorithmError) Error() string {
+	return fmt.Sprintf("Unsupported password hashing algorithm: %s", e.Algorithm)
+}
+
+// isHashed tells if a password is stored as a hash
+func isHashed(pass string) bool {
+	for _, prefix := range hashPrefixes {
+		if strings.HasPrefix(pass, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func orDefault(value, defaultValue int) int {
+	if value <= 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// newHasher creates the hasher of the configured algorithm, bcrypt with a cost of 10 by default
+func newHasher(conf *confpar.PasswordHashing) (algorithm.Hash, error) {
+	if conf == nil {
+		conf = &confpar.PasswordHashing{}
+	}
+
+	switch conf.Algorithm {
+	case "", HashBcrypt:
+		return bcrypt.New(bcrypt.WithCost(orDefault(conf.Cost, defaultBcryptCost)))
+	case HashArgon2id:
+		return argon2.New(
+			argon2.WithVariantID(),
+			argon2.WithT(orDefault(conf.Cost, defaultArgon2Iterations)),
+			argon2.WithM(uint32(orDefault(conf.Memory, defaultArgon2Memory))), //nolint:gosec // checked by WithM
+			argon2.WithP(orDefault(conf.Parallelism, defaultArgon2Parallelism)),
+			// Fills the unset key and salt lengths, which aren't defaulted by the hasher
+			argon2.WithProfileRFC9106LowMemory(),
+		)
+	case HashScrypt:
+		return scrypt.New(
+			scrypt.WithLN(orDefault(conf.Cost, defaultScryptLN)),
+			scrypt.WithP(orDefault(conf.Parallelism, defaultScryptParallelism)),
+		)
+	default:
+		return nil, UnsupportedHashAlgorithmError{Algorithm: conf.Algorithm}
+	}
+}
+
+// hashParams returns the algorithm and parameters of an encoded hash, without its salt and key.
+// Two hashes with the same parameters were computed the same way.
+func hashParams(encoded string) string {
+	// bcrypt appends the key to the salt, the other algorithms separate them
+	trailing := 2
+	if strings.HasPrefix(encoded, "$2") {
+		trailing = 1
+	}
+
+	for range trailing {
+		if i := strings.LastIndex(encoded, "$"); i >= 0 {
+			encoded = encoded[:i]
+		}
+	}
+
+	return encoded
+}
+
+// targetHashParams returns the parameters of the hashes produced by the configured algorithm
+func targetHashParams(conf *confpar.PasswordHashing) (string, error) {
+	hasher, err := newHasher(conf)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := hasher.Hash("")
+	if err != nil {
+		return "", err
+	}
+
+	return hashParams(digest.Encode()), nil
+}
+
+// matchPassword checks a password against the stored one, which can be hashed or in plain text
+func matchPassword(decoder *crypt.Decoder, stored, pass string) (bool, error) {
+	if !isHashed(stored) {
+		return stored == pass, nil
+	}
+
+	digest, err := decoder.Decode(stored)
+	if err != nil {
+		return false, err
+	}
+
+	return digest.MatchAdvanced(pass)
+}
+
+// needsRehash tells if the stored password of an access should be upgraded to the configured algorithm, it must be
+// called with the lock held
+func (c *Config) needsRehash(stored string) bool {
+	conf := c.Content.PasswordHashing
+
+	return conf != nil && conf.Rehash && c.loaded && c.targetHash != "" && isHashed(stored) &&
+		hashParams(stored) != c.targetHash
+}
+
+// rehash replaces the stored password of an access by a hash of the configured algorithm, in memory and in the
+// config file. It's skipped if the access or its entry in the file changed in the meantime.
+func (c *Config) rehash(access *confpar.Access, pass string) error {
+	c.mu.RLock()
+	hasher, err := newHasher(c.Content.PasswordHashing)
+	c.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Hashing is slow on purpose, it's done without holding the lock
+	digest, err := hasher.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := -1
+
+	for i, a := range c.Content.Accesses {
+		if a == access {
+			index = i
+
+			break
+		}
+	}
+
+	old := access.Pass
+	if index < 0 || !c.needsRehash(old) {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.fileName)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("accesses.%d.pass", index)
+	if gjson.GetBytes(data, path).String() != old {
+		return nil
+	}
+
+	modified, err := sjson.SetBytes(data, path, digest.Encode())
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(c.fileName, modified, 0o644); err != nil { //nolint:gosec // same mode as the hashed passwords
+		return err
+	}
+
+	access.Pass = digest.Encode()
+	c.logger.Info("Upgraded password hash", "user", access.User, "hash", hashParams(access.Pass))
+
+	return nil
+}
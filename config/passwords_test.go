@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+	"github.com/tidwall/gjson"
+)
+
+func TestRehash(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "ftpserver.json")
+	content := `{
+		"hash_plaintext_passwords": true,
+		"password_hashing": {"algorithm": "scrypt", "cost": 10, "rehash": true},
+		"accesses": [
+			{"user": "old", "pass": "$1$salt$qJH7.N4xYta3aEG/dfqo/0", "fs": "os"},
+			{"user": "plain", "pass": "secret", "fs": "os"}
+		]
+	}`
+
+	if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := NewConfig(fileName, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(i int) string {
+		data, errRead := os.ReadFile(fileName)
+		if errRead != nil {
+			t.Fatal(errRead)
+		}
+
+		return gjson.GetBytes(data, fmt.Sprintf("accesses.%d.pass", i)).String()
+	}
+
+	if !strings.HasPrefix(stored(1), "$scrypt$ln=10,") {
+		t.Fatal("The plain-text password wasn't hashed with the configured algorithm:", stored(1))
+	}
+
+	if _, err = conf.GetAccess("old", "wrong", "127.0.0.1"); err == nil {
+		t.Fatal("The wrong password was accepted")
+	}
+
+	if !strings.HasPrefix(stored(0), "$1$") {
+		t.Fatal("The hash was upgraded after a failed login")
+	}
+
+	for range 2 {
+		if _, err = conf.GetAccess("old", "password", "127.0.0.1"); err != nil {
+			t.Fatal("The password wasn't accepted:", err)
+		}
+
+		if !strings.HasPrefix(stored(0), "$scrypt$ln=10,") || conf.Content.Accesses[0].Pass != stored(0) {
+			t.Fatal("The hash wasn't upgraded:", stored(0))
+		}
+	}
+}
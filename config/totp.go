@@ -0,0 +1,109 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // TOTP (RFC 6238) uses HMAC-SHA1 by default
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod    = 30 // Seconds of validity of a code
+	totpDigits    = 6
+	totpSkew      = 1   // Periods accepted before and after the current one, for the clock drifts
+	totpSeparator = ":" // Separates the password from the code
+)
+
+// ErrInvalidTOTPCode is returned when the TOTP code appended to the password is missing, wrong or already used
+var ErrInvalidTOTPCode = errors.New("invalid TOTP code")
+
+// decodeTOTPSecret decodes a base32 secret, as shown by the authenticator apps
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// splitTOTPCode separates the password from the TOTP code appended to it
+func splitTOTPCode(pass string) (string, string) {
+	i := strings.LastIndex(pass, totpSeparator)
+	if i < 0 {
+		return pass, ""
+	}
+
+	return pass[:i], pass[i+len(totpSeparator):]
+}
+
+// totpCode computes the code of a period (RFC 4226 and RFC 6238)
+func totpCode(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// totpVerifier checks the TOTP codes and remembers the last period used by each user to refuse replayed codes
+type totpVerifier struct {
+	mu   sync.Mutex
+	used map[string]uint64 // Last period used per user
+	now  func() time.Time
+}
+
+func newTOTPVerifier() *totpVerifier {
+	return &totpVerifier{
+		used: make(map[string]uint64),
+		now:  time.Now,
+	}
+}
+
+// verify checks the code of a user and returns its period. A code can only be used once and the older ones are
+// refused once a code of a later period was used, the period of the code is only recorded by use.
+func (v *totpVerifier) verify(user, secret, code string) (uint64, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, ErrInvalidTOTPCode
+	}
+
+	current := uint64(v.now().Unix() / totpPeriod) //nolint:gosec // the time is positive
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) != 1 {
+			continue
+		}
+
+		if counter <= v.used[user] {
+			return 0, ErrInvalidTOTPCode
+		}
+
+		return counter, nil
+	}
+
+	return 0, ErrInvalidTOTPCode
+}
+
+// use records the period of the code a user logged in with, it fails if this code or a later one was used meanwhile
+func (v *totpVerifier) use(user string, period uint64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if period <= v.used[user] {
+		return ErrInvalidTOTPCode
+	}
+
+	v.used[user] = period
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestTOTP(t *testing.T) {
+	// Test vectors of RFC 6238, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	if totpCode(key, 59/totpPeriod) != "287082" || totpCode(key, 1111111109/totpPeriod) != "081804" {
+		t.Fatal("Unexpected codes")
+	}
+
+	secret := base32.StdEncoding.EncodeToString(key)
+	verifier := newTOTPVerifier()
+	verifier.now = func() time.Time { return time.Unix(1111111109, 0) }
+
+	if _, err := verifier.verify("user", secret, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatal("A wrong code was accepted")
+	}
+
+	// The code of the previous period is still accepted
+	previous, err := verifier.verify("user", secret, totpCode(key, 1111111109/totpPeriod-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	period, err := verifier.verify("user", secret, "081804")
+	if err != nil || period != previous+1 {
+		t.Fatal("Unexpected period:", period, err)
+	}
+
+	if err = verifier.use("user", period); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = verifier.verify("user", secret, "081804"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatal("A code was replayed")
+	}
+
+	// The code was used by a concurrent login
+	if err = verifier.use("user", period); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatal("A code was used twice")
+	}
+
+	if _, err = verifier.verify("other", secret, "081804"); err != nil {
+		t.Fatal(err)
+	}
+
+	if password, code := splitTOTPCode("secret:123:482913"); password != "secret:123" || code != "482913" {
+		t.Fatal("Unexpected split:", password, code)
+	}
+}
+
+func TestTOTPAccess(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.EncodeToString(key)
+	past := time.Now().Add(-time.Hour)
+
+	conf, err := FromContent(&confpar.Content{
+		Accesses: []*confpar.Access{
+			{User: "alice", Pass: "pass", Fs: "expired", TOTPSecret: secret, ValidUntil: &past},
+			{User: "alice", Pass: "pass", Fs: "os", TOTPSecret: secret},
+		},
+	}, "", lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.totp.now = func() time.Time { return time.Unix(1111111109, 0) }
+
+	// A wrong code is refused like a wrong password
+	if _, err = conf.GetAccess("alice", "pass:000000", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// The expired access doesn't use the code
+	access, err := conf.GetAccess("alice", "pass:081804", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.Fs != "os" {
+		t.Fatal("Unexpected access:", access)
+	}
+
+	// The code is only used once the session is accepted
+	if access, err = conf.GetAccess("alice", "pass:081804", ""); err != nil {
+		t.Fatal("The code was used by a refused login:", err)
+	}
+
+	if err = conf.UseAccess("alice", access); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = conf.GetAccess("alice", "pass:081804", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatal("A code was replayed:", err)
+	}
+}
@@ -0,0 +1,248 @@
+// Package events provides the notification of file operations to a webhook
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Event types
+const (
+	Upload   = "upload"
+	Download = "download"
+	Delete   = "delete"
+	Rename   = "rename"
+	Mkdir    = "mkdir"
+)
+
+// SignatureHeader is the header containing the HMAC-SHA256 signature of the payload
+const SignatureHeader = "X-Ftpserver-Signature"
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 5
+	defaultQueueSize  = 1000
+	firstRetryDelay   = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// ErrMissingURL is returned when the url property isn't specified
+var ErrMissingURL = errors.New("events webhook url must be specified")
+
+// Event describes a file operation
+type Event struct {
+	Type       string    `json:"type"`                  // Event type
+	Time       time.Time `json:"time"`                  // Time of the event
+	User       string    `json:"user"`                  // User who performed the operation
+	Path       string    `json:"path"`                  // Path of the file
+	NewPath    string    `json:"new_path,omitempty"`    // New path of a renamed file
+	Size       int64     `json:"size,omitempty"`        // Bytes transferred
+	Duration   float64   `json:"duration,omitempty"`    // Duration of the transfer in seconds
+	Fs         string    `json:"fs"`                    // Backend type
+	RemoteAddr string    `json:"remote_addr,omitempty"` // Client address
+	ClientID   uint32    `json:"client_id,omitempty"`   // Client ID
+}
+
+// Emitter receives the events, it must not block
+type Emitter interface {
+	Emit(event *Event)
+}
+
+// Emitters dispatches the events to several emitters
+type Emitters []Emitter
+
+// Emit sends the event to all the emitters
+func (e Emitters) Emit(event *Event) {
+	for _, emitter := range e {
+		emitter.Emit(event)
+	}
+}
+
+// Notifier sends the events to a webhook. Events are delivered asynchronously, in order, and retried
+// with an exponential backoff.
+type Notifier struct {
+	conf   *confpar.EventsWebhook
+	logger log.Logger
+	client *http.Client
+	types  map[string]bool
+	queue  *queue
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewNotifier creates a notifier and starts delivering the pending events
+func NewNotifier(conf *confpar.EventsWebhook, logger log.Logger) (*Notifier, error) {
+	if conf.URL == "" {
+		return nil, ErrMissingURL
+	}
+
+	queueSize := conf.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	q, err := newQueue(conf.QueueDir, queueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	var types map[string]bool
+
+	if len(conf.Events) > 0 {
+		types = make(map[string]bool, len(conf.Events))
+		for _, t := range conf.Events {
+			types[t] = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := &Notifier{
+		conf:   conf,
+		logger: logger,
+		client: &http.Client{Timeout: timeout},
+		types:  types,
+		queue:  q,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go n.run()
+
+	return n, nil
+}
+
+// Emit queues an event. It never blocks, events are dropped if the queue is full.
+func (n *Notifier) Emit(event *Event) {
+	if n == nil || (n.types != nil && !n.types[event.Type]) {
+		return
+	}
+
+	if err := n.queue.push(event); err != nil {
+		n.logger.Warn("Dropping event", "type", event.Type, "path", event.Path, "err", err)
+	}
+}
+
+// Stop stops the delivery, pending events stay on disk if a queue directory is used
+func (n *Notifier) Stop() {
+	if n == nil {
+		return
+	}
+
+	n.cancel()
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for {
+		item := n.queue.peek(n.ctx)
+		if item == nil {
+			return
+		}
+
+		if err := n.deliverWithRetries(item.event); err != nil {
+			if n.ctx.Err() != nil {
+				return
+			}
+
+			n.logger.Error("Could not deliver event", "type", item.event.Type, "path", item.event.Path, "err", err)
+		}
+
+		n.queue.remove(item)
+	}
+}
+
+func (n *Notifier) maxRetries() int {
+	if n.conf.MaxRetries > 0 {
+		return n.conf.MaxRetries
+	}
+
+	return defaultMaxRetries
+}
+
+func (n *Notifier) deliverWithRetries(event *Event) error {
+	delay := firstRetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := n.deliver(event)
+		if err == nil || attempt >= n.maxRetries() {
+			return err
+		}
+
+		n.logger.Warn("Event delivery failed, retrying", "type", event.Type, "attempt", attempt+1, "err", err)
+
+		select {
+		case <-time.After(delay):
+		case <-n.ctx.Done():
+			return n.ctx.Err()
+		}
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (n *Notifier) deliver(event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.conf.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for key, value := range n.conf.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if n.conf.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.conf.Secret, payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode) //nolint: goerr113
+	}
+
+	return nil
+}
+
+// Sign returns the signature of a payload, as sent in the SignatureHeader
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestNotifier(t *testing.T) {
+	var calls atomic.Int32
+
+	received := make(chan *Event, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and must be retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("Invalid signature: %s", r.Header.Get(SignatureHeader))
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+		}
+
+		received <- &event
+	}))
+	defer srv.Close()
+
+	notifier, err := NewNotifier(&confpar.EventsWebhook{
+		URL:    srv.URL,
+		Secret: "secret",
+		Events: []string{Upload},
+	}, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer notifier.Stop()
+
+	notifier.Emit(&Event{Type: Delete, Path: "/ignored"})
+	notifier.Emit(&Event{Type: Upload, User: "user", Path: "/file", Size: 42})
+
+	select {
+	case event := <-received:
+		if event.Type != Upload || event.Path != "/file" || event.Size != 42 {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Event not delivered")
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newQueue(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.push(&Event{Type: Mkdir, Path: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.push(&Event{Type: Mkdir, Path: "/b"}); err != ErrQueueFull { //nolint:errorlint
+		t.Fatalf("Expected a full queue, got %v", err)
+	}
+
+	// The pending event is loaded again
+	q, err = newQueue(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := q.peek(t.Context())
+	if item.event.Path != "/a" {
+		t.Fatalf("Unexpected event: %+v", item.event)
+	}
+
+	q.remove(item)
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Expected an empty queue directory, got %d files", len(entries))
+	}
+}
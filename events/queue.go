@@ -0,0 +1,170 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when too many events are waiting for delivery
+var ErrQueueFull = errors.New("events queue is full")
+
+const queueFileSuffix = ".json"
+
+type queueItem struct {
+	event *Event
+	file  string // File persisting the event, if any
+}
+
+// queue holds the events waiting for delivery, in memory and optionally in a directory so that they
+// survive a restart
+type queue struct {
+	dir    string
+	size   int
+	mu     sync.Mutex
+	items  []*queueItem
+	seq    uint64
+	notify chan struct{}
+}
+
+func newQueue(dir string, size int) (*queue, error) {
+	q := &queue{
+		dir:    dir,
+		size:   size,
+		notify: make(chan struct{}, 1),
+	}
+
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create events queue directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read events queue directory: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), queueFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	// File names start with a fixed width timestamp
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+
+		event, err := readEvent(file)
+		if err != nil {
+			// A partially written event can't be delivered anyway
+			_ = os.Remove(file)
+
+			continue
+		}
+
+		q.items = append(q.items, &queueItem{event: event, file: file})
+	}
+
+	if len(q.items) > 0 {
+		q.notify <- struct{}{}
+	}
+
+	return q, nil
+}
+
+func readEvent(file string) (*Event, error) {
+	data, err := os.ReadFile(file) //nolint:gosec // files of our own queue
+	if err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (q *queue) push(event *Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) >= q.size {
+		return ErrQueueFull
+	}
+
+	item := &queueItem{event: event}
+
+	if q.dir != "" {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		q.seq++
+		item.file = filepath.Join(q.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), q.seq%1000000, queueFileSuffix))
+
+		if err := os.WriteFile(item.file, data, 0o600); err != nil {
+			return fmt.Errorf("could not persist event: %w", err)
+		}
+	}
+
+	q.items = append(q.items, item)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// peek returns the oldest event, waiting for one if the queue is empty. It returns nil once the context
+// is done.
+func (q *queue) peek(ctx context.Context) *queueItem {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.mu.Unlock()
+
+			return item
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// remove drops an event returned by peek
+func (q *queue) remove(item *queueItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) > 0 && q.items[0] == item {
+		q.items = q.items[1:]
+	}
+
+	if item.file != "" {
+		_ = os.Remove(item.file)
+	}
+}
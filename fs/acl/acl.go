@@ -0,0 +1,276 @@
+// Package acl provides an afero FS layer enforcing per-path permissions
+package acl
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Operation is an operation that can be allowed on a path
+type Operation string
+
+// Supported operations
+const (
+	OpList      Operation = "list"      // List a directory
+	OpDownload  Operation = "download"  // Read a file
+	OpUpload    Operation = "upload"    // Create a new file
+	OpOverwrite Operation = "overwrite" // Modify an existing file, including its metadata
+	OpRename    Operation = "rename"    // Rename a file or directory, checked on both paths
+	OpDelete    Operation = "delete"    // Delete a file or directory
+	OpMkdir     Operation = "mkdir"     // Create a directory
+)
+
+var operations = map[Operation]bool{
+	OpList:      true,
+	OpDownload:  true,
+	OpUpload:    true,
+	OpOverwrite: true,
+	OpRename:    true,
+	OpDelete:    true,
+	OpMkdir:     true,
+}
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+// UnknownOperationError is returned when a permission uses an unknown operation
+type UnknownOperationError struct {
+	error
+	Operation string
+}
+
+func (err UnknownOperationError) Error() string {
+	return fmt.Sprintf("Unknown operation: %s", err.Operation)
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	allow   map[Operation]bool
+}
+
+// Fs is a wrapper checking the permissions of each operation. The first rule matching a path defines
+// the allowed operations, any operation on a path matching no rule is denied.
+type Fs struct {
+	src   afero.Fs
+	rules []*rule
+}
+
+// LoadFs creates an instance enforcing the given permissions
+func LoadFs(src afero.Fs, permissions []*confpar.Permission) (afero.Fs, error) {
+	rules := make([]*rule, 0, len(permissions))
+
+	for _, permission := range permissions {
+		pattern, err := compileGlob(permission.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission path %s: %w", permission.Path, err)
+		}
+
+		allow := make(map[Operation]bool, len(permission.Allow))
+
+		for _, op := range permission.Allow {
+			if !operations[Operation(op)] {
+				return nil, &UnknownOperationError{Operation: op}
+			}
+
+			allow[Operation(op)] = true
+		}
+
+		rules = append(rules, &rule{pattern: pattern, allow: allow})
+	}
+
+	return &Fs{src: src, rules: rules}, nil
+}
+
+// compileGlob converts a glob to a regular expression: "**" matches any number of directories,
+// "*" and "?" don't match the path separator.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	glob = path.Clean("/" + glob)
+
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "/**"):
+			expr.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return regexp.Compile("^" + expr.String() + "$")
+}
+
+func (f *Fs) allowed(op Operation, name string) bool {
+	name = path.Clean("/" + name)
+
+	for _, r := range f.rules {
+		if r.pattern.MatchString(name) {
+			return r.allow[op]
+		}
+	}
+
+	return false
+}
+
+func (f *Fs) check(op Operation, name string) error {
+	if !f.allowed(op, name) {
+		return &os.PathError{Op: string(op), Path: name, Err: os.ErrPermission}
+	}
+
+	return nil
+}
+
+// checkOpen checks the operation implied by opening a file
+func (f *Fs) checkOpen(name string, flag int) error {
+	info, errStat := f.src.Stat(name)
+
+	switch {
+	case flag&writeFlags == 0 && errStat == nil && info.IsDir():
+		return f.check(OpList, name)
+	case flag&writeFlags == 0:
+		return f.check(OpDownload, name)
+	case errStat == nil:
+		return f.check(OpOverwrite, name)
+	default:
+		return f.check(OpUpload, name)
+	}
+}
+
+// Create checks the upload or overwrite permission
+func (f *Fs) Create(name string) (afero.File, error) {
+	if err := f.checkOpen(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR); err != nil {
+		return nil, err
+	}
+
+	return f.src.Create(name)
+}
+
+// Mkdir checks the mkdir permission
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, name); err != nil {
+		return err
+	}
+
+	return f.src.Mkdir(name, perm)
+}
+
+// MkdirAll checks the mkdir permission
+func (f *Fs) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, name); err != nil {
+		return err
+	}
+
+	return f.src.MkdirAll(name, perm)
+}
+
+// Open checks the list permission for directories and the download permission for files
+func (f *Fs) Open(name string) (afero.File, error) {
+	if err := f.checkOpen(name, os.O_RDONLY); err != nil {
+		return nil, err
+	}
+
+	return f.src.Open(name)
+}
+
+// OpenFile checks the permission matching the flags
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if err := f.checkOpen(name, flag); err != nil {
+		return nil, err
+	}
+
+	return f.src.OpenFile(name, flag, perm)
+}
+
+// Remove checks the delete permission
+func (f *Fs) Remove(name string) error {
+	if err := f.check(OpDelete, name); err != nil {
+		return err
+	}
+
+	return f.src.Remove(name)
+}
+
+// RemoveAll checks the delete permission
+func (f *Fs) RemoveAll(name string) error {
+	if err := f.check(OpDelete, name); err != nil {
+		return err
+	}
+
+	return f.src.RemoveAll(name)
+}
+
+// Rename checks the rename permission on both paths
+func (f *Fs) Rename(oldname, newname string) error {
+	if err := f.check(OpRename, oldname); err != nil {
+		return err
+	}
+
+	if err := f.check(OpRename, newname); err != nil {
+		return err
+	}
+
+	return f.src.Rename(oldname, newname)
+}
+
+// Stat is always allowed
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	return f.src.Stat(name)
+}
+
+// LstatIfPossible is always allowed
+func (f *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if lstater, ok := f.src.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+
+	info, err := f.src.Stat(name)
+
+	return info, false, err
+}
+
+// Name of the underlying file system
+func (f *Fs) Name() string {
+	return f.src.Name()
+}
+
+// Chmod checks the overwrite permission
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	if err := f.check(OpOverwrite, name); err != nil {
+		return err
+	}
+
+	return f.src.Chmod(name, mode)
+}
+
+// Chtimes checks the overwrite permission
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := f.check(OpOverwrite, name); err != nil {
+		return err
+	}
+
+	return f.src.Chtimes(name, atime, mtime)
+}
+
+// Chown checks the overwrite permission
+func (f *Fs) Chown(name string, uid int, gid int) error {
+	if err := f.check(OpOverwrite, name); err != nil {
+		return err
+	}
+
+	return f.src.Chown(name, uid, gid)
+}
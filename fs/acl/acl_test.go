@@ -0,0 +1,66 @@
+package acl
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestPermissions(t *testing.T) {
+	src := afero.NewMemMapFs()
+	_ = src.MkdirAll("/incoming", 0o755)
+	_ = src.MkdirAll("/archive", 0o755)
+	_ = afero.WriteFile(src, "/archive/old.txt", []byte("old"), 0o644)
+	_ = afero.WriteFile(src, "/incoming/existing.txt", []byte("old"), 0o644)
+
+	fs, err := LoadFs(src, []*confpar.Permission{
+		{Path: "/incoming/**", Allow: []string{"upload", "mkdir"}},
+		{Path: "/archive/**", Allow: []string{"download"}},
+		{Path: "/**", Allow: []string{"list"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		err     error
+		allowed bool
+	}{
+		{afero.WriteFile(fs, "/incoming/new.txt", []byte("new"), 0o644), true},
+		{afero.WriteFile(fs, "/incoming/existing.txt", []byte("new"), 0o644), false},
+		{fs.Mkdir("/incoming/dir", 0o755), true},
+		{fs.Mkdir("/archive/dir", 0o755), false},
+		{fs.Remove("/archive/old.txt"), false},
+		{fs.Rename("/incoming/new.txt", "/archive/new.txt"), false},
+		{afero.WriteFile(fs, "/archive/new.txt", []byte("new"), 0o644), false},
+	}
+
+	for i, check := range checks {
+		if denied := errors.Is(check.err, os.ErrPermission); denied == check.allowed {
+			t.Errorf("Check %d: unexpected result %v", i, check.err)
+		}
+	}
+
+	if _, err := afero.ReadFile(fs, "/archive/old.txt"); err != nil {
+		t.Error("Download should be allowed", err)
+	}
+
+	if _, err := afero.ReadDir(fs, "/"); err != nil {
+		t.Error("Listing should be allowed", err)
+	}
+
+	// "/archive/**" matches the directory itself and doesn't allow to list it
+	if _, err := afero.ReadDir(fs, "/archive"); !errors.Is(err, os.ErrPermission) {
+		t.Error("Listing should be denied", err)
+	}
+}
+
+func TestUnknownOperation(t *testing.T) {
+	if _, err := LoadFs(afero.NewMemMapFs(), []*confpar.Permission{{Path: "/**", Allow: []string{"fly"}}}); err == nil {
+		t.Error("Unknown operation should be rejected")
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"github.com/spf13/afero"
 
 	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/acl"
 	"github.com/fclairamb/ftpserver/fs/afos"
 	"github.com/fclairamb/ftpserver/fs/dropbox"
+	"github.com/fclairamb/ftpserver/fs/fsatomic"
+	"github.com/fclairamb/ftpserver/fs/fsquota"
 	"github.com/fclairamb/ftpserver/fs/gdrive"
 	"github.com/fclairamb/ftpserver/fs/mail"
 	"github.com/fclairamb/ftpserver/fs/s3"
@@ -28,8 +31,9 @@ func (err UnsupportedFsError) Error() string {
 	return fmt.Sprintf("Unsupported FS: %s", err.Type)
 }
 
-// LoadFs loads a file system from an access description
-func LoadFs(access *confpar.Access, logger log.Logger) (afero.Fs, error) {
+// LoadFs loads a file system from an access description. The quota of the access is only enforced when
+// quotas is provided.
+func LoadFs(access *confpar.Access, logger log.Logger, quotas *fsquota.Registry) (afero.Fs, error) {
 	var fs afero.Fs
 	var err error
 
@@ -71,5 +75,18 @@ func LoadFs(access *confpar.Access, logger log.Logger) (afero.Fs, error) {
 		})
 	}
 
+	if err == nil && access.AtomicUploads {
+		fs = fsatomic.LoadFs(fs)
+	}
+
+	if err == nil && quotas != nil && access.Quota != nil {
+		fs = fsquota.LoadFs(fs, quotas.Get(access, fs))
+	}
+
+	// Permissions are checked before reaching any other layer
+	if err == nil && len(access.Permissions) > 0 {
+		fs, err = acl.LoadFs(fs, access.Permissions)
+	}
+
 	return fs, err
 }
@@ -0,0 +1,110 @@
+// Package fsatomic provides an afero FS layer making the uploads appear atomically
+package fsatomic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// Fs is a wrapper writing the uploaded files to a hidden temporary file, renamed to the final name once the
+// upload completed. Appends and resumed uploads are written in place.
+type Fs struct {
+	afero.Fs
+}
+
+// File is an uploaded file, written to a temporary file
+type File struct {
+	afero.File
+	fs     *Fs
+	name   string // Final name of the file
+	temp   string // Name of the temporary file
+	failed bool   // The transfer failed
+}
+
+// LoadFs creates an instance writing the uploads atomically
+func LoadFs(src afero.Fs) afero.Fs {
+	return &Fs{Fs: src}
+}
+
+// tempName returns the name of the temporary file of an upload: ".<name>.part-<id>"
+func tempName(name string) (string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return path.Join(path.Dir(name), "."+path.Base(name)+".part-"+hex.EncodeToString(id)), nil
+}
+
+// Create writes the file to a temporary file
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// OpenFile writes the file to a temporary file if it's truncated, it's opened as is otherwise
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_TRUNC == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f.Fs.OpenFile(name, flag, perm)
+	}
+
+	temp, err := tempName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.Fs.OpenFile(temp, flag|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{File: file, fs: f, name: name, temp: temp}, nil
+}
+
+// replace renames a file, removing the destination first if the backend can't rename over an existing file
+func (f *Fs) replace(oldname, newname string) error {
+	err := f.Fs.Rename(oldname, newname)
+	if err == nil {
+		return nil
+	}
+
+	if _, errStat := f.Fs.Stat(newname); errStat != nil {
+		return err
+	}
+
+	if errRemove := f.Fs.Remove(newname); errRemove != nil {
+		return err
+	}
+
+	return f.Fs.Rename(oldname, newname)
+}
+
+// Name returns the final name of the file
+func (f *File) Name() string {
+	return f.name
+}
+
+// TransferError is called by the server when a transfer fails, the temporary file is then deleted
+func (f *File) TransferError(_ error) {
+	f.failed = true
+}
+
+// Close renames the temporary file to the final name, or deletes it if the upload failed
+func (f *File) Close() error {
+	err := f.File.Close()
+
+	if err == nil && !f.failed {
+		err = f.fs.replace(f.temp, f.name)
+	}
+
+	if err != nil || f.failed {
+		if errRemove := f.fs.Fs.Remove(f.temp); errRemove != nil && !os.IsNotExist(errRemove) && err == nil {
+			err = errRemove
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,61 @@
+package fsatomic
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+var errTransfer = errors.New("transfer failed")
+
+func TestAtomicUpload(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	fs := LoadFs(mem)
+
+	file, err := fs.OpenFile("/dir/file.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mem.Stat("/dir/file.txt"); !os.IsNotExist(err) {
+		t.Fatal("The file shouldn't be visible before the upload completes")
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := afero.ReadFile(mem, "/dir/file.txt"); err != nil || string(content) != "hello" {
+		t.Fatal("Unexpected content", string(content), err)
+	}
+
+	// A failed upload doesn't replace the file
+	file, err = fs.OpenFile("/dir/file.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+
+	file.(*File).TransferError(errTransfer)
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := afero.ReadFile(mem, "/dir/file.txt"); string(content) != "hello" {
+		t.Fatal("The file was replaced by a failed upload", string(content))
+	}
+
+	if names, _ := afero.ReadDir(mem, "/dir"); len(names) != 1 {
+		t.Fatal("The temporary file wasn't deleted", len(names))
+	}
+}
@@ -0,0 +1,265 @@
+// Package fsevents provides an afero FS layer notifying file operations
+package fsevents
+
+import (
+	"os"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/events"
+)
+
+// Fs is a wrapper emitting an event for each completed transfer, deletion, renaming and directory creation
+type Fs struct {
+	src      afero.Fs       // Source file system
+	emitter  events.Emitter // Emitter receiving the events
+	template events.Event   // User, backend and client of the events
+}
+
+// File is a wrapper emitting an event when a transfer completes
+type File struct {
+	src           afero.File // Source file
+	fs            *Fs        // Associated file system
+	name          string     // Path of the file
+	opened        time.Time  // Opening time
+	lengthRead    int64      // Length read
+	lengthWritten int64      // Length written
+	writing       bool       // The file was opened for writing
+	failed        bool       // The transfer failed
+}
+
+func (f *Fs) emit(eventType, name, newName string, size int64, duration time.Duration) {
+	event := f.template
+	event.Type = eventType
+	event.Time = time.Now().UTC()
+	event.Path = name
+	event.NewPath = newName
+	event.Size = size
+	event.Duration = duration.Seconds()
+
+	f.emitter.Emit(&event)
+}
+
+func (f *Fs) wrap(name string, flag int, src afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		src:     src,
+		fs:      f,
+		name:    name,
+		opened:  time.Now(),
+		writing: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+	}, nil
+}
+
+// Create returns a file notifying the upload
+func (f *Fs) Create(name string) (afero.File, error) {
+	src, err := f.src.Create(name)
+
+	return f.wrap(name, os.O_RDWR, src, err)
+}
+
+// Mkdir calls will be notified
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	err := f.src.Mkdir(name, perm)
+	if err == nil {
+		f.emit(events.Mkdir, name, "", 0, 0)
+	}
+
+	return err
+}
+
+// MkdirAll calls will be notified
+func (f *Fs) MkdirAll(path string, perm os.FileMode) error {
+	err := f.src.MkdirAll(path, perm)
+	if err == nil {
+		f.emit(events.Mkdir, path, "", 0, 0)
+	}
+
+	return err
+}
+
+// Open returns a file notifying the download
+func (f *Fs) Open(name string) (afero.File, error) {
+	src, err := f.src.Open(name)
+
+	return f.wrap(name, os.O_RDONLY, src, err)
+}
+
+// OpenFile returns a file notifying the transfer
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	src, err := f.src.OpenFile(name, flag, perm)
+
+	return f.wrap(name, flag, src, err)
+}
+
+// Remove calls will be notified
+func (f *Fs) Remove(name string) error {
+	err := f.src.Remove(name)
+	if err == nil {
+		f.emit(events.Delete, name, "", 0, 0)
+	}
+
+	return err
+}
+
+// RemoveAll calls will be notified
+func (f *Fs) RemoveAll(path string) error {
+	err := f.src.RemoveAll(path)
+	if err == nil {
+		f.emit(events.Delete, path, "", 0, 0)
+	}
+
+	return err
+}
+
+// Rename calls will be notified
+func (f *Fs) Rename(oldname, newname string) error {
+	err := f.src.Rename(oldname, newname)
+	if err == nil {
+		f.emit(events.Rename, oldname, newname, 0, 0)
+	}
+
+	return err
+}
+
+// Stat calls will not be notified
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	return f.src.Stat(name)
+}
+
+// Name calls will not be notified
+func (f *Fs) Name() string {
+	return f.src.Name()
+}
+
+// Chmod calls will not be notified
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	return f.src.Chmod(name, mode)
+}
+
+// Chtimes calls will not be notified
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return f.src.Chtimes(name, atime, mtime)
+}
+
+// Chown calls will not be notified
+func (f *Fs) Chown(name string, uid int, gid int) error {
+	return f.src.Chown(name, uid, gid)
+}
+
+// TransferError is called by the server when a transfer fails, no event is then emitted
+func (f *File) TransferError(err error) {
+	f.failed = true
+
+	if src, ok := f.src.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
+
+// Close emits an upload event if the file was opened for writing, even if it's empty, or a download event if some data
+// was read. Nothing is emitted if the transfer failed.
+func (f *File) Close() error {
+	err := f.src.Close()
+
+	if err != nil || f.failed {
+		return err
+	}
+
+	if f.writing {
+		f.fs.emit(events.Upload, f.name, "", f.lengthWritten, time.Since(f.opened))
+	} else if f.lengthRead > 0 {
+		f.fs.emit(events.Download, f.name, "", f.lengthRead, time.Since(f.opened))
+	}
+
+	return nil
+}
+
+// Read calls will be counted
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.src.Read(p)
+	f.lengthRead += int64(n)
+
+	return n, err
+}
+
+// ReadAt calls will be counted
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.src.ReadAt(p, off)
+	f.lengthRead += int64(n)
+
+	return n, err
+}
+
+// Seek calls will not be notified
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.src.Seek(offset, whence)
+}
+
+// Write calls will be counted
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.src.Write(p)
+	f.lengthWritten += int64(n)
+
+	return n, err
+}
+
+// WriteAt calls will be counted
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.src.WriteAt(p, off)
+	f.lengthWritten += int64(n)
+
+	return n, err
+}
+
+// Name calls will not be notified
+func (f *File) Name() string {
+	return f.src.Name()
+}
+
+// Readdir calls will not be notified
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	return f.src.Readdir(count)
+}
+
+// Readdirnames calls will not be notified
+func (f *File) Readdirnames(n int) ([]string, error) {
+	return f.src.Readdirnames(n)
+}
+
+// Stat calls will not be notified
+func (f *File) Stat() (os.FileInfo, error) {
+	return f.src.Stat()
+}
+
+// Sync calls will not be notified
+func (f *File) Sync() error {
+	return f.src.Sync()
+}
+
+// Truncate calls will not be notified
+func (f *File) Truncate(size int64) error {
+	return f.src.Truncate(size)
+}
+
+// WriteString calls will be counted
+func (f *File) WriteString(str string) (int, error) {
+	n, err := f.src.WriteString(str)
+	f.lengthWritten += int64(n)
+
+	return n, err
+}
+
+// LoadFS creates an instance notifying the operations of a client. The user, backend and client fields of
+// the template are copied into each event.
+func LoadFS(src afero.Fs, emitter events.Emitter, template *events.Event) (afero.Fs, error) {
+	return &Fs{
+		src:      src,
+		emitter:  emitter,
+		template: *template,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package fsevents
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/events"
+)
+
+type recorder []*events.Event
+
+func (r *recorder) Emit(event *events.Event) {
+	*r = append(*r, event)
+}
+
+func TestEmptyUpload(t *testing.T) {
+	var emitted recorder
+
+	fs, err := LoadFS(afero.NewMemMapFs(), &emitted, &events.Event{User: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty STOR opens the file for writing and closes it without writing anything
+	file, err := fs.OpenFile("/empty.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emitted) != 1 || emitted[0].Type != events.Upload || emitted[0].Path != "/empty.txt" ||
+		emitted[0].Size != 0 || emitted[0].User != "user" {
+		t.Fatal("Unexpected events:", emitted)
+	}
+
+	// Opening a file without reading it isn't a download
+	if file, err = fs.Open("/empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed upload isn't notified
+	if file, err = fs.OpenFile("/failed.txt", os.O_WRONLY|os.O_CREATE, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file.(*File).TransferError(errors.New("connection reset"))
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emitted) != 1 {
+		t.Fatal("Unexpected events:", emitted)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	serverlib "github.com/fclairamb/ftpserverlib"
 	"github.com/spf13/afero"
 
 	log "github.com/fclairamb/go-log"
@@ -124,6 +125,15 @@ func (f *Fs) Chown(name string, uid int, gid int) error {
 	return f.src.Chown(name, uid, gid)
 }
 
+// TransferError calls will be logged and forwarded
+func (f *File) TransferError(err error) {
+	f.logger.Warn("Transfer failed", "err", err)
+
+	if src, ok := f.src.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
+
 // Close calls will be logged
 func (f *File) Close() error {
 	err := f.src.Close()
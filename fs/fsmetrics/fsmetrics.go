@@ -0,0 +1,295 @@
+// Package fsmetrics provides an afero FS metrics collecting package
+package fsmetrics
+
+import (
+	"os"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+// File is a wrapper to collect metrics around file accesses
+type File struct {
+	src           afero.File // Source file
+	fs            *Fs        // Associated file system
+	opened        time.Time  // Opening time
+	lengthRead    int        // Length read
+	lengthWritten int        // Length written
+}
+
+// Fs is a wrapper to collect metrics around file system accesses
+type Fs struct {
+	src        afero.Fs           // Source file system
+	metrics    *metrics.Metrics   // Metrics to update
+	fsType     string             // Backend type
+	uploaded   prometheus.Counter // Bytes uploaded through the access
+	downloaded prometheus.Counter // Bytes downloaded through the access
+}
+
+func (f *Fs) observe(operation string, start time.Time, err error) {
+	f.metrics.ObserveOperation(f.fsType, operation, start, err)
+}
+
+func (f *Fs) wrap(src afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		src:    src,
+		fs:     f,
+		opened: time.Now(),
+	}, nil
+}
+
+// Create calls will be measured
+func (f *Fs) Create(name string) (afero.File, error) {
+	start := time.Now()
+	src, err := f.src.Create(name)
+	f.observe("create", start, err)
+
+	return f.wrap(src, err)
+}
+
+// Mkdir calls will be measured
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	start := time.Now()
+	err := f.src.Mkdir(name, perm)
+	f.observe("mkdir", start, err)
+
+	return err
+}
+
+// MkdirAll calls will be measured
+func (f *Fs) MkdirAll(path string, perm os.FileMode) error {
+	start := time.Now()
+	err := f.src.MkdirAll(path, perm)
+	f.observe("mkdir_all", start, err)
+
+	return err
+}
+
+// Open calls will be measured
+func (f *Fs) Open(name string) (afero.File, error) {
+	start := time.Now()
+	src, err := f.src.Open(name)
+	f.observe("open", start, err)
+
+	return f.wrap(src, err)
+}
+
+// OpenFile calls will be measured
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	start := time.Now()
+	src, err := f.src.OpenFile(name, flag, perm)
+	f.observe("open_file", start, err)
+
+	return f.wrap(src, err)
+}
+
+// Remove calls will be measured
+func (f *Fs) Remove(name string) error {
+	start := time.Now()
+	err := f.src.Remove(name)
+	f.observe("remove", start, err)
+
+	return err
+}
+
+// RemoveAll calls will be measured
+func (f *Fs) RemoveAll(path string) error {
+	start := time.Now()
+	err := f.src.RemoveAll(path)
+	f.observe("remove_all", start, err)
+
+	return err
+}
+
+// Rename calls will be measured
+func (f *Fs) Rename(oldname, newname string) error {
+	start := time.Now()
+	err := f.src.Rename(oldname, newname)
+	f.observe("rename", start, err)
+
+	return err
+}
+
+// Stat calls will be measured
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	info, err := f.src.Stat(name)
+	f.observe("stat", start, err)
+
+	return info, err
+}
+
+// Name calls will not be measured
+func (f *Fs) Name() string {
+	return f.src.Name()
+}
+
+// Chmod calls will be measured
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	start := time.Now()
+	err := f.src.Chmod(name, mode)
+	f.observe("chmod", start, err)
+
+	return err
+}
+
+// Chtimes calls will be measured
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	start := time.Now()
+	err := f.src.Chtimes(name, atime, mtime)
+	f.observe("chtimes", start, err)
+
+	return err
+}
+
+// Chown calls will be measured
+func (f *Fs) Chown(name string, uid int, gid int) error {
+	start := time.Now()
+	err := f.src.Chown(name, uid, gid)
+	f.observe("chown", start, err)
+
+	return err
+}
+
+// TransferError calls will be forwarded
+func (f *File) TransferError(err error) {
+	if src, ok := f.src.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
+
+// Close calls will be measured, the transfer duration is recorded if some data was transferred
+func (f *File) Close() error {
+	start := time.Now()
+	err := f.src.Close()
+	f.fs.observe("close", start, err)
+
+	if f.lengthWritten > 0 {
+		f.fs.metrics.ObserveTransfer(metrics.Upload, f.fs.fsType, time.Since(f.opened))
+	} else if f.lengthRead > 0 {
+		f.fs.metrics.ObserveTransfer(metrics.Download, f.fs.fsType, time.Since(f.opened))
+	}
+
+	return err
+}
+
+func (f *File) read(n int) {
+	f.lengthRead += n
+	f.fs.downloaded.Add(float64(n))
+}
+
+func (f *File) written(n int) {
+	f.lengthWritten += n
+	f.fs.uploaded.Add(float64(n))
+}
+
+// Read calls will be counted
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.src.Read(p)
+	f.read(n)
+
+	return n, err
+}
+
+// ReadAt calls will be counted
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.src.ReadAt(p, off)
+	f.read(n)
+
+	return n, err
+}
+
+// Seek calls will not be measured
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.src.Seek(offset, whence)
+}
+
+// Write calls will be counted
+func (f *File) Write(p []byte) (int, error) {
+	n, err := f.src.Write(p)
+	f.written(n)
+
+	return n, err
+}
+
+// WriteAt calls will be counted
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.src.WriteAt(p, off)
+	f.written(n)
+
+	return n, err
+}
+
+// Name calls will not be measured
+func (f *File) Name() string {
+	return f.src.Name()
+}
+
+// Readdir calls will be measured
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	start := time.Now()
+	infos, err := f.src.Readdir(count)
+	f.fs.observe("readdir", start, err)
+
+	return infos, err
+}
+
+// Readdirnames calls will be measured
+func (f *File) Readdirnames(n int) ([]string, error) {
+	start := time.Now()
+	names, err := f.src.Readdirnames(n)
+	f.fs.observe("readdir", start, err)
+
+	return names, err
+}
+
+// Stat calls will not be measured
+func (f *File) Stat() (os.FileInfo, error) {
+	return f.src.Stat()
+}
+
+// Sync calls will be measured
+func (f *File) Sync() error {
+	start := time.Now()
+	err := f.src.Sync()
+	f.fs.observe("sync", start, err)
+
+	return err
+}
+
+// Truncate calls will be measured
+func (f *File) Truncate(size int64) error {
+	start := time.Now()
+	err := f.src.Truncate(size)
+	f.fs.observe("truncate", start, err)
+
+	return err
+}
+
+// WriteString calls will be counted
+func (f *File) WriteString(str string) (int, error) {
+	n, err := f.src.WriteString(str)
+	f.written(n)
+
+	return n, err
+}
+
+// LoadFS creates an instance collecting metrics for an access and a backend type. The access is the name of the
+// configured access or template, not the user, to keep the number of series bounded.
+func LoadFS(src afero.Fs, m *metrics.Metrics, access, fsType string) (afero.Fs, error) {
+	return &Fs{
+		src:        src,
+		metrics:    m,
+		fsType:     fsType,
+		uploaded:   m.TransferBytes(metrics.Upload, access, fsType),
+		downloaded: m.TransferBytes(metrics.Download, access, fsType),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package fsmetrics
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+func TestTransfer(t *testing.T) {
+	m := metrics.New(func() float64 { return 0 })
+
+	fs, err := LoadFS(afero.NewMemMapFs(), m, "cameras", "os")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := fs.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = file.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if file, err = fs.Open("/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = io.ReadAll(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP ftpserver_transfer_bytes_total Number of bytes transferred per direction, access and backend.
+# TYPE ftpserver_transfer_bytes_total counter
+ftpserver_transfer_bytes_total{access="cameras",direction="download",fs="os"} 5
+ftpserver_transfer_bytes_total{access="cameras",direction="upload",fs="os"} 5
+`
+	if err = testutil.GatherAndCompare(
+		m.Gatherer(), strings.NewReader(expected), "ftpserver_transfer_bytes_total",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := m.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]uint64{}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "direction" || label.GetName() == "operation" {
+					counts[family.GetName()+":"+label.GetValue()] = metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"ftpserver_transfer_duration_seconds:upload",
+		"ftpserver_transfer_duration_seconds:download",
+		"ftpserver_backend_operation_duration_seconds:create",
+		"ftpserver_backend_operation_duration_seconds:open",
+	} {
+		if counts[name] != 1 {
+			t.Fatal("Unexpected observations:", name, counts[name])
+		}
+	}
+
+	if counts["ftpserver_backend_operation_duration_seconds:close"] != 2 {
+		t.Fatal("Unexpected observations of close:", counts)
+	}
+}
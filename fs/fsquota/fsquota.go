@@ -0,0 +1,258 @@
+// Package fsquota provides an afero FS layer enforcing a quota on the stored bytes and files
+package fsquota
+
+import (
+	"os"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+)
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+// Fs is a wrapper tracking the usage of a file system and rejecting the writes exceeding the quota
+type Fs struct {
+	afero.Fs
+	usage *Usage
+}
+
+// File is a file opened for writing, its growth is checked against the quota
+type File struct {
+	afero.File
+	fs     *Fs
+	name   string
+	before int64 // Size before the opening, -1 if it didn't exist
+	size   int64 // Current size
+	pos    int64 // Current position
+	bytes  int64 // Bytes added to the usage
+	files  int64 // Files added to the usage
+}
+
+// LoadFs creates an instance enforcing the quota of a usage
+func LoadFs(src afero.Fs, usage *Usage) afero.Fs {
+	return &Fs{Fs: src, usage: usage}
+}
+
+// fileSize returns the size of a file, -1 if it doesn't exist or is a directory
+func (f *Fs) fileSize(name string) int64 {
+	info, err := f.Fs.Stat(name)
+	if err != nil || info.IsDir() {
+		return -1
+	}
+
+	return info.Size()
+}
+
+// Create checks the files quota
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// OpenFile checks the files quota and tracks the writes
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&writeFlags == 0 {
+		return f.Fs.OpenFile(name, flag, perm)
+	}
+
+	file := &File{fs: f, name: name, before: f.fileSize(name)}
+
+	switch {
+	case file.before < 0:
+		file.files = 1
+	case flag&os.O_TRUNC != 0:
+		file.bytes = -file.before
+	default:
+		file.size = file.before
+	}
+
+	if err := f.usage.add(file.bytes, file.files, true); err != nil {
+		return nil, err
+	}
+
+	src, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		_ = f.usage.add(-file.bytes, -file.files, false)
+
+		return nil, err
+	}
+
+	file.File = src
+
+	if flag&os.O_APPEND != 0 {
+		file.pos = file.size
+	}
+
+	return file, nil
+}
+
+// Remove updates the usage
+func (f *Fs) Remove(name string) error {
+	size := f.fileSize(name)
+
+	if err := f.Fs.Remove(name); err != nil {
+		return err
+	}
+
+	if size >= 0 {
+		f.update(-size, -1)
+	}
+
+	return nil
+}
+
+// RemoveAll updates the usage
+func (f *Fs) RemoveAll(path string) error {
+	var bytes, files int64
+
+	_ = afero.Walk(f.Fs, path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			bytes += info.Size()
+			files++
+		}
+
+		return nil
+	})
+
+	if err := f.Fs.RemoveAll(path); err != nil {
+		return err
+	}
+
+	f.update(-bytes, -files)
+
+	return nil
+}
+
+// Rename updates the usage when a file is replaced
+func (f *Fs) Rename(oldname, newname string) error {
+	size := f.fileSize(newname)
+
+	if err := f.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	if size >= 0 {
+		f.update(-size, -1)
+	}
+
+	return nil
+}
+
+func (f *Fs) update(bytes, files int64) {
+	if err := f.usage.add(bytes, files, false); err == nil {
+		f.usage.persist()
+	}
+}
+
+// grow checks the quota before writing n bytes at off, and returns the bytes added to the usage
+func (f *File) grow(off int64, n int) (int64, error) {
+	growth := off + int64(n) - f.size
+	if growth <= 0 {
+		return 0, nil
+	}
+
+	return growth, f.fs.usage.add(growth, 0, true)
+}
+
+// written updates the usage once n bytes out of the reserved ones were written at off
+func (f *File) written(off int64, n int, reserved int64) {
+	growth := max(off+int64(n)-f.size, 0)
+
+	if growth != reserved {
+		_ = f.fs.usage.add(growth-reserved, 0, false)
+	}
+
+	f.bytes += growth
+	f.size += growth
+}
+
+// Write checks the bytes quota
+func (f *File) Write(p []byte) (int, error) {
+	reserved, err := f.grow(f.pos, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(p)
+	f.written(f.pos, n, reserved)
+	f.pos += int64(n)
+
+	return n, err
+}
+
+// WriteAt checks the bytes quota
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	reserved, err := f.grow(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.WriteAt(p, off)
+	f.written(off, n, reserved)
+
+	return n, err
+}
+
+// WriteString checks the bytes quota
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Seek tracks the position of the writes
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+
+	return pos, err
+}
+
+// Truncate checks the bytes quota
+func (f *File) Truncate(size int64) error {
+	reserved, err := f.grow(0, int(size))
+	if err != nil {
+		return err
+	}
+
+	if err := f.File.Truncate(size); err != nil {
+		_ = f.fs.usage.add(-reserved, 0, false)
+
+		return err
+	}
+
+	_ = f.fs.usage.add(size-f.size-reserved, 0, false)
+	f.bytes += size - f.size
+	f.size = size
+
+	return nil
+}
+
+// TransferError forwards the transfer errors
+func (f *File) TransferError(err error) {
+	if src, ok := f.File.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
+
+// Close reconciles the usage with the actual size of the file, which might have been discarded by a
+// lower layer
+func (f *File) Close() error {
+	err := f.File.Close()
+
+	after := f.fs.fileSize(f.name)
+
+	var bytes, files int64
+
+	if after >= 0 {
+		bytes, files = after, 1
+	}
+
+	if f.before >= 0 {
+		bytes -= f.before
+		files--
+	}
+
+	f.fs.update(bytes-f.bytes, files-f.files)
+
+	return err
+}
@@ -0,0 +1,82 @@
+package fsquota
+
+import (
+	"errors"
+	"testing"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	lognoop "github.com/fclairamb/go-log/noop"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestQuota(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	if err := afero.WriteFile(mem, "/existing.txt", make([]byte, 10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	access := &confpar.Access{User: "test", Quota: &confpar.Quota{MaxBytes: 100, MaxFiles: 2}}
+	fs := LoadFs(mem, NewRegistry(dir, lognoop.NewNoOpLogger()).Get(access, mem))
+
+	if err := afero.WriteFile(fs, "/a.txt", make([]byte, 50), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := afero.WriteFile(fs, "/a.txt", make([]byte, 90), 0o644); err != nil {
+		t.Fatal("Overwriting a file should release its size", err)
+	}
+
+	if err := afero.WriteFile(fs, "/a.txt", make([]byte, 91), 0o644); !errors.Is(err, serverlib.ErrStorageExceeded) {
+		t.Fatal("Expected a storage error, got", err)
+	}
+
+	if _, err := fs.Create("/b.txt"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("Expected a files quota error, got", err)
+	}
+
+	if err := fs.Remove("/existing.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The usage is loaded again from the persisted file, a.txt was truncated by the rejected write
+	usage := NewRegistry(dir, lognoop.NewNoOpLogger()).Get(access, afero.NewMemMapFs())
+	if bytes, files, err := usage.Get(); err != nil || bytes != 0 || files != 1 {
+		t.Fatal("Unexpected usage", bytes, files, err)
+	}
+}
+
+func TestSharedBackend(t *testing.T) {
+	shared, other := afero.NewMemMapFs(), afero.NewMemMapFs()
+	registry := NewRegistry(t.TempDir(), lognoop.NewNoOpLogger())
+
+	alice := LoadFs(shared, registry.Get(&confpar.Access{
+		User: "alice", Fs: "os", Params: map[string]string{"basePath": "/srv/shared"}, Quota: &confpar.Quota{MaxFiles: 2},
+	}, shared))
+	bob := LoadFs(shared, registry.Get(&confpar.Access{
+		User: "bob", Fs: "os", Params: map[string]string{"basePath": "/srv/shared"}, Quota: &confpar.Quota{MaxFiles: 2},
+	}, shared))
+	carol := LoadFs(other, registry.Get(&confpar.Access{
+		User: "carol", Fs: "os", Params: map[string]string{"basePath": "/srv/carol"}, Quota: &confpar.Quota{MaxFiles: 1},
+	}, other))
+
+	if err := afero.WriteFile(alice, "/a.txt", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := afero.WriteFile(bob, "/b.txt", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files of both users count against the quota of the backend
+	if _, err := alice.Create("/c.txt"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatal("Expected a files quota error, got", err)
+	}
+
+	// Another backend has its own usage
+	if err := afero.WriteFile(carol, "/a.txt", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
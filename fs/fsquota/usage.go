@@ -0,0 +1,270 @@
+package fsquota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	log "github.com/fclairamb/go-log"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+// ErrQuotaExceeded is returned when an operation would exceed the quota, it's replied with a 552 code
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded: %w", serverlib.ErrStorageExceeded)
+
+// ErrNoBytesQuota is returned when asking for the available space of an access without bytes quota
+var ErrNoBytesQuota = errors.New("no bytes quota")
+
+// Registry holds the usage of each backend, shared by all the sessions of the accesses using it
+type Registry struct {
+	dir      string // Directory persisting the usages
+	logger   log.Logger
+	mu       sync.Mutex
+	counters map[string]*counter // Usage of each backend
+	usages   map[string]*Usage   // Limits of each access
+}
+
+// NewRegistry creates a registry, the usages are persisted in dir if it's not empty
+func NewRegistry(dir string, logger log.Logger) *Registry {
+	return &Registry{
+		dir:      dir,
+		logger:   logger,
+		counters: make(map[string]*counter),
+		usages:   make(map[string]*Usage),
+	}
+}
+
+// backendKey identifies the backend of an access by its fs and params, the accesses using the same backend share
+// its usage
+func backendKey(access *confpar.Access) string {
+	keys := make([]string, 0, len(access.Params))
+	for key := range access.Params {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(hash, "%q=%q\n", key, access.Params[key])
+	}
+
+	return utils.SanitizePathElement(access.Fs) + "-" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// Get returns the usage of the backend of an access, limited by the quota of the access. src is the file system
+// scanned to compute the initial usage.
+func (r *Registry) Get(access *confpar.Access, src afero.Fs) *Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := backendKey(access)
+
+	c := r.counters[key]
+	if c == nil {
+		c = &counter{logger: r.logger.With("backend", key)}
+
+		if r.dir != "" {
+			c.file = filepath.Join(r.dir, key+".json")
+			c.load()
+		}
+
+		r.counters[key] = c
+	}
+
+	usage := r.usages[access.User]
+	if usage == nil || usage.counter != c {
+		usage = &Usage{counter: c}
+		r.usages[access.User] = usage
+	}
+
+	// The limits are updated on each login to follow the config reloads
+	c.mu.Lock()
+	usage.maxBytes, usage.maxFiles = access.Quota.MaxBytes, access.Quota.MaxFiles
+
+	if src != nil {
+		c.src = src
+	}
+	c.mu.Unlock()
+
+	return usage
+}
+
+// Usage is the usage of a backend seen by an access, limited by the quota of the access
+type Usage struct {
+	*counter
+	maxBytes int64 // Protected by the lock of the counter
+	maxFiles int64
+}
+
+// counter tracks the bytes and files stored in a backend
+type counter struct {
+	logger  log.Logger
+	mu      sync.Mutex
+	src     afero.Fs // File system to scan
+	file    string   // File persisting the usage
+	scanned bool     // The usage is known
+	bytes   int64
+	files   int64
+}
+
+type persistedUsage struct {
+	Bytes   int64     `json:"bytes"`
+	Files   int64     `json:"files"`
+	Updated time.Time `json:"updated"`
+}
+
+func (u *counter) load() {
+	data, err := os.ReadFile(u.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			u.logger.Warn("Could not read quota usage", "file", u.file, "err", err)
+		}
+
+		return
+	}
+
+	var persisted persistedUsage
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		u.logger.Warn("Invalid quota usage, it will be computed again", "file", u.file, "err", err)
+
+		return
+	}
+
+	u.bytes, u.files, u.scanned = persisted.Bytes, persisted.Files, true
+}
+
+// save persists the usage, it must be called with the lock held
+func (u *counter) save() {
+	if u.file == "" || !u.scanned {
+		return
+	}
+
+	data, err := json.Marshal(&persistedUsage{Bytes: u.bytes, Files: u.files, Updated: time.Now().UTC()})
+	if err != nil {
+		return
+	}
+
+	temp := u.file + ".tmp"
+
+	if err = os.MkdirAll(filepath.Dir(u.file), 0o750); err == nil {
+		if err = os.WriteFile(temp, data, 0o600); err == nil {
+			err = os.Rename(temp, u.file)
+		}
+	}
+
+	if err != nil {
+		u.logger.Warn("Could not save quota usage", "file", u.file, "err", err)
+	}
+}
+
+// scan computes the usage from the file system, it must be called with the lock held
+func (u *counter) scan() error {
+	if u.scanned {
+		return nil
+	}
+
+	start := time.Now()
+
+	var bytes, files int64
+
+	err := afero.Walk(u.src, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable sub-directories are ignored
+			if path == "/" {
+				return err
+			}
+
+			return nil
+		}
+
+		if !info.IsDir() {
+			bytes += info.Size()
+			files++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not compute quota usage: %w", err)
+	}
+
+	u.bytes, u.files, u.scanned = bytes, files, true
+	u.logger.Info("Computed quota usage", "bytes", bytes, "files", files, "duration", time.Since(start))
+	u.save()
+
+	return nil
+}
+
+// add updates the usage, the quota is only checked when check is true and the usage grows
+func (u *Usage) add(bytes, files int64, check bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.scan(); err != nil {
+		return err
+	}
+
+	if check &&
+		((bytes > 0 && u.maxBytes > 0 && u.bytes+bytes > u.maxBytes) ||
+			(files > 0 && u.maxFiles > 0 && u.files+files > u.maxFiles)) {
+		return ErrQuotaExceeded
+	}
+
+	u.bytes += bytes
+	u.files += files
+
+	return nil
+}
+
+// persist saves the usage
+func (u *counter) persist() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.save()
+}
+
+// Get returns the current usage
+func (u *Usage) Get() (bytes, files int64, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.scan(); err != nil {
+		return 0, 0, err
+	}
+
+	return u.bytes, u.files, nil
+}
+
+// Available returns the bytes that can still be stored
+func (u *Usage) Available() (int64, error) {
+	bytes, _, err := u.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.maxBytes <= 0 {
+		return 0, ErrNoBytesQuota
+	}
+
+	if bytes > u.maxBytes {
+		return 0, nil
+	}
+
+	return u.maxBytes - bytes, nil
+}
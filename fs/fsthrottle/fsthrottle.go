@@ -0,0 +1,102 @@
+package fsthrottle
+
+import (
+	"os"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+)
+
+// Fs is a wrapper limiting the transfer rates of the files of a session
+type Fs struct {
+	afero.Fs
+	session *Session
+}
+
+// File is a wrapper limiting the transfer rates of a file
+type File struct {
+	afero.File
+	session *Session
+}
+
+// LoadFS creates an instance applying the limits of a session
+func LoadFS(src afero.Fs, session *Session) (afero.Fs, error) {
+	return &Fs{Fs: src, session: session}, nil
+}
+
+func (f *Fs) wrap(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{File: file, session: f.session}, nil
+}
+
+// Create returns a throttled file
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Create(name))
+}
+
+// Open returns a throttled file
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Open(name))
+}
+
+// OpenFile returns a throttled file
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return f.wrap(f.Fs.OpenFile(name, flag, perm))
+}
+
+// Read limits the download rate
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if errWait := f.session.wait(false, n); errWait != nil {
+		return n, errWait
+	}
+
+	return n, err
+}
+
+// ReadAt limits the download rate
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if errWait := f.session.wait(false, n); errWait != nil {
+		return n, errWait
+	}
+
+	return n, err
+}
+
+// Write limits the upload rate
+func (f *File) Write(p []byte) (int, error) {
+	if err := f.session.wait(true, len(p)); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(p)
+}
+
+// WriteAt limits the upload rate
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.session.wait(true, len(p)); err != nil {
+		return 0, err
+	}
+
+	return f.File.WriteAt(p, off)
+}
+
+// WriteString limits the upload rate
+func (f *File) WriteString(s string) (int, error) {
+	if err := f.session.wait(true, len(s)); err != nil {
+		return 0, err
+	}
+
+	return f.File.WriteString(s)
+}
+
+// TransferError forwards the transfer errors
+func (f *File) TransferError(err error) {
+	if src, ok := f.File.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package fsthrottle provides an afero FS layer limiting the transfer rates
+package fsthrottle
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// minBurst is the minimum amount of bytes that can be transferred at once
+const minBurst = 32 * 1024
+
+// limiter limits the upload and download rates
+type limiter struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+	sessions int    // Sessions using this limiter
+	template string // Configured access or template of a per-access limiter
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		upload:   rate.NewLimiter(rate.Inf, 0),
+		download: rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+func setLimit(l *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		l.SetLimit(rate.Inf)
+
+		return
+	}
+
+	// The burst is set first so that it's never 0 with a finite limit
+	l.SetBurst(int(min(max(bytesPerSecond, minBurst), math.MaxInt32)))
+	l.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+func (l *limiter) set(conf *confpar.Bandwidth) {
+	var upload, download int64
+
+	if conf != nil {
+		upload, download = conf.Upload, conf.Download
+	}
+
+	setLimit(l.upload, upload)
+	setLimit(l.download, download)
+}
+
+// Throttler holds the global, per-access and per-IP limiters
+type Throttler struct {
+	mu        sync.Mutex
+	global    *limiter
+	perIPConf *confpar.Bandwidth
+	perIP     map[string]*limiter
+	perAccess map[string]*limiter
+}
+
+// NewThrottler creates a throttler applying the global and per-IP limits
+func NewThrottler(global, perIP *confpar.Bandwidth) *Throttler {
+	t := &Throttler{
+		global:    newLimiter(),
+		perIP:     make(map[string]*limiter),
+		perAccess: make(map[string]*limiter),
+	}
+
+	t.Update(global, perIP)
+
+	return t
+}
+
+// Update changes the global and per-IP limits, the current sessions are affected
+func (t *Throttler) Update(global, perIP *confpar.Bandwidth) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.global.set(global)
+	t.perIPConf = perIP
+
+	for _, l := range t.perIP {
+		l.set(perIP)
+	}
+}
+
+// UpdateTemplate changes the limits of the connected sessions of the accesses coming from a configured access or
+// template, including the users matching its pattern
+func (t *Throttler) UpdateTemplate(template string, conf *confpar.Bandwidth) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, l := range t.perAccess {
+		if l.template == template {
+			l.set(conf)
+		}
+	}
+}
+
+func acquire(limiters map[string]*limiter, key string) *limiter {
+	l := limiters[key]
+	if l == nil {
+		l = newLimiter()
+		limiters[key] = l
+	}
+
+	l.sessions++
+
+	return l
+}
+
+func release(limiters map[string]*limiter, key string) {
+	if l := limiters[key]; l != nil {
+		if l.sessions--; l.sessions <= 0 {
+			delete(limiters, key)
+		}
+	}
+}
+
+// Acquire returns the limits of a session, shared with the other sessions of the same IP and access.
+// The limits of the access are updated. The transfers waiting for their limits stop when ctx is done.
+func (t *Throttler) Acquire(ctx context.Context, ip string, access *confpar.Access) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	perIP := acquire(t.perIP, ip)
+	perIP.set(t.perIPConf)
+
+	perAccess := acquire(t.perAccess, access.User)
+	perAccess.set(access.Bandwidth)
+	perAccess.template = access.Template
+
+	return &Session{
+		ctx:       ctx,
+		throttler: t,
+		ip:        ip,
+		access:    access.User,
+		limiters:  []*limiter{t.global, perAccess, perIP},
+	}
+}
+
+// Session applies the limits of a session
+type Session struct {
+	ctx       context.Context // Ends the waiting transfers when the session ends
+	throttler *Throttler
+	ip        string
+	access    string
+	limiters  []*limiter
+	released  bool
+}
+
+// Release must be called once the session ends
+func (s *Session) Release() {
+	if s == nil {
+		return
+	}
+
+	t := s.throttler
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s.released {
+		return
+	}
+
+	s.released = true
+	release(t.perIP, s.ip)
+	release(t.perAccess, s.access)
+}
+
+// wait blocks until n bytes can be transferred in a direction, or the session ends
+func (s *Session) wait(upload bool, n int) error {
+	for _, l := range s.limiters {
+		rl := l.download
+		if upload {
+			rl = l.upload
+		}
+
+		// Transfers larger than the burst are split
+		for remaining := n; remaining > 0; {
+			chunk := remaining
+			if rl.Limit() != rate.Inf && chunk > rl.Burst() {
+				chunk = rl.Burst()
+			}
+
+			if err := rl.WaitN(s.ctx, chunk); err != nil {
+				if s.ctx.Err() != nil {
+					return s.ctx.Err()
+				}
+
+				// The limit changed while waiting
+				continue
+			}
+
+			remaining -= chunk
+		}
+	}
+
+	return nil
+}
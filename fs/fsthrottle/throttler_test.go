@@ -0,0 +1,74 @@
+package fsthrottle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestThrottler(t *testing.T) {
+	throttler := NewThrottler(nil, &confpar.Bandwidth{Download: 1000})
+	access := &confpar.Access{User: "test", Bandwidth: &confpar.Bandwidth{Upload: 2000}}
+
+	s1 := throttler.Acquire(context.Background(), "1.2.3.4", access)
+	s2 := throttler.Acquire(context.Background(), "1.2.3.4", access)
+
+	if s1.limiters[2] != s2.limiters[2] {
+		t.Fatal("Sessions of the same IP should share their limiter")
+	}
+
+	if s1.limiters[1].upload.Limit() != 2000 || s1.limiters[2].download.Limit() != 1000 {
+		t.Fatal("Unexpected limits")
+	}
+
+	throttler.Update(&confpar.Bandwidth{Upload: 3000}, nil)
+
+	if s1.limiters[0].upload.Limit() != 3000 || s1.limiters[2].download.Limit() != rate.Inf {
+		t.Fatal("Limits weren't updated")
+	}
+
+	s1.Release()
+	s1.Release()
+
+	if len(throttler.perIP) != 1 {
+		t.Fatal("The limiter is still used by a session")
+	}
+
+	s2.Release()
+
+	if len(throttler.perIP) != 0 || len(throttler.perAccess) != 0 {
+		t.Fatal("Limiters weren't released")
+	}
+}
+
+func TestTemplateUpdate(t *testing.T) {
+	throttler := NewThrottler(nil, nil)
+	session := throttler.Acquire(context.Background(), "1.2.3.4", &confpar.Access{
+		User: "alice", Template: "*", Bandwidth: &confpar.Bandwidth{Upload: 2000},
+	})
+
+	// The users matching the pattern of an access are updated with it
+	throttler.UpdateTemplate("*", &confpar.Bandwidth{Upload: 3000})
+
+	if session.limiters[1].upload.Limit() != 3000 {
+		t.Fatal("Limits weren't updated")
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	session := NewThrottler(&confpar.Bandwidth{Upload: minBurst}, nil).Acquire(ctx, "1.2.3.4", &confpar.Access{})
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// A kicked session doesn't stay blocked by its limits
+	start := time.Now()
+	if err := session.wait(true, 10*minBurst); !errors.Is(err, context.Canceled) || time.Since(start) > 2*time.Second {
+		t.Fatal("Unexpected wait:", err, time.Since(start))
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var placeholder = regexp.MustCompile(`\{[a-zA-Z0-9_-]+\}`)
+
+// ReplacePlaceholders replaces all the {name} placeholders of a string by their values.
+// Values are sanitized so that they can't escape their parent directory, unknown placeholders are kept.
+func ReplacePlaceholders(s string, values map[string]string) string {
+	return replacePlaceholders(s, values, SanitizePathElement)
+}
+
+// ExpandPlaceholders replaces all the {name} placeholders of a string by their values, as is.
+// Unknown placeholders are kept.
+func ExpandPlaceholders(s string, values map[string]string) string {
+	return replacePlaceholders(s, values, func(value string) string { return value })
+}
+
+func replacePlaceholders(s string, values map[string]string, transform func(string) string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(s string) string {
+		value, ok := values[s[1:len(s)-1]]
+		if !ok {
+			return s
+		}
+
+		return transform(value)
+	})
+}
+
+// SanitizePathElement makes a value safe to use as a single path element
+func SanitizePathElement(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+
+		return r
+	}, value)
+
+	if value == "" || value == "." || value == ".." {
+		return "_"
+	}
+
+	return value
+}
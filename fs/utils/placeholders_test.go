@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestReplacePlaceholders(t *testing.T) {
+	values := map[string]string{"user": "john", "evil": "../../etc"}
+
+	if value := ReplacePlaceholders("/data/{user}/{other}", values); value != "/data/john/{other}" {
+		t.Error("ReplacePlaceholders failed", value)
+	}
+
+	if value := ReplacePlaceholders("/data/{evil}", values); value != "/data/.._.._etc" {
+		t.Error("ReplacePlaceholders didn't sanitize", value)
+	}
+
+	if value := ExpandPlaceholders("{evil}/{other}", values); value != "../../etc/{other}" {
+		t.Error("ExpandPlaceholders failed", value)
+	}
+
+	if value := SanitizePathElement(".."); value != "_" {
+		t.Error("SanitizePathElement failed", value)
+	}
+}
@@ -0,0 +1,154 @@
+// Package hooks provides the commands run after file operations
+package hooks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
+	"github.com/fclairamb/ftpserver/fs/utils"
+)
+
+const (
+	defaultTimeout       = time.Minute
+	defaultMaxConcurrent = 4
+	maxLoggedOutput      = 1024
+)
+
+// Runner runs the hook commands in the background, limiting how many run at the same time
+type Runner struct {
+	logger  log.Logger
+	timeout time.Duration
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+// NewRunner creates a runner, conf can be nil to use the default settings
+func NewRunner(conf *confpar.Hooks, logger log.Logger) *Runner {
+	timeout, maxConcurrent := defaultTimeout, defaultMaxConcurrent
+
+	if conf != nil {
+		if conf.Timeout > 0 {
+			timeout = conf.Timeout
+		}
+
+		if conf.MaxConcurrent > 0 {
+			maxConcurrent = conf.MaxConcurrent
+		}
+	}
+
+	return &Runner{
+		logger:  logger,
+		timeout: timeout,
+		slots:   make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Wait waits for the running and pending commands to complete
+func (r *Runner) Wait() {
+	r.running.Wait()
+}
+
+// OnUpload returns an emitter running the on_upload command of an access after each upload
+func (r *Runner) OnUpload(access *confpar.Access) events.Emitter {
+	hook := &uploadHook{runner: r, command: access.OnUpload}
+
+	// The real path of the files is only known for the os backend
+	if access.Fs == "os" {
+		hook.basePath = utils.ReplaceEnvVars(access.Params["basePath"])
+	}
+
+	return hook
+}
+
+type uploadHook struct {
+	runner   *Runner
+	command  []string
+	basePath string
+}
+
+// Emit runs the command in the background for upload events
+func (h *uploadHook) Emit(event *events.Event) {
+	if event.Type != events.Upload {
+		return
+	}
+
+	values := map[string]string{
+		"user":        event.User,
+		"path":        event.Path,
+		"size":        strconv.FormatInt(event.Size, 10),
+		"duration":    strconv.FormatFloat(event.Duration, 'f', 3, 64),
+		"fs":          event.Fs,
+		"remote_addr": event.RemoteAddr,
+		"client_id":   strconv.FormatUint(uint64(event.ClientID), 10),
+	}
+
+	if h.basePath != "" {
+		values["local_path"] = filepath.Join(h.basePath, filepath.FromSlash(event.Path))
+	}
+
+	args := make([]string, len(h.command))
+	for i, arg := range h.command {
+		args[i] = utils.ExpandPlaceholders(arg, values)
+	}
+
+	env := os.Environ()
+	for key, value := range values {
+		env = append(env, "FTP_"+strings.ToUpper(key)+"="+value)
+	}
+
+	h.runner.running.Add(1)
+
+	go h.runner.run(args, env, event)
+}
+
+func (r *Runner) run(args, env []string, event *events.Event) {
+	defer r.running.Done()
+
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) //nolint:gosec // command of the config
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+
+	if len(output) > maxLoggedOutput {
+		output = output[:maxLoggedOutput]
+	}
+
+	logger := r.logger.With(
+		"command", args[0],
+		"path", event.Path,
+		"user", event.User,
+		"duration", time.Since(start),
+		"exitCode", cmd.ProcessState.ExitCode(),
+	)
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case ctx.Err() != nil:
+		logger.Error("Hook timed out", "output", string(output))
+	case errors.As(err, &exitErr):
+		logger.Warn("Hook failed", "output", string(output))
+	case err != nil:
+		logger.Error("Could not run hook", "err", err)
+	default:
+		logger.Info("Hook completed")
+	}
+}
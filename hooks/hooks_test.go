@@ -0,0 +1,131 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/fclairamb/go-log"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
+)
+
+type logEntry struct {
+	level  string
+	event  string
+	fields map[string]interface{}
+}
+
+// recordingLogger keeps the logged entries
+type recordingLogger struct {
+	mu      *sync.Mutex
+	entries *[]*logEntry
+	keyvals []interface{}
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{mu: &sync.Mutex{}, entries: &[]*logEntry{}}
+}
+
+func (l *recordingLogger) log(level, event string, keyvals []interface{}) {
+	entry := &logEntry{level: level, event: event, fields: map[string]interface{}{}}
+
+	keyvals = append(append([]interface{}{}, l.keyvals...), keyvals...)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry.fields[keyvals[i].(string)] = keyvals[i+1]
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	*l.entries = append(*l.entries, entry)
+}
+
+func (l *recordingLogger) Debug(event string, keyvals ...interface{}) { l.log("debug", event, keyvals) }
+func (l *recordingLogger) Info(event string, keyvals ...interface{})  { l.log("info", event, keyvals) }
+func (l *recordingLogger) Warn(event string, keyvals ...interface{})  { l.log("warn", event, keyvals) }
+func (l *recordingLogger) Error(event string, keyvals ...interface{}) { l.log("error", event, keyvals) }
+func (l *recordingLogger) Panic(event string, _ ...interface{})       { panic(event) }
+
+func (l *recordingLogger) With(keyvals ...interface{}) log.Logger {
+	keyvals = append(append([]interface{}{}, l.keyvals...), keyvals...)
+
+	return &recordingLogger{mu: l.mu, entries: l.entries, keyvals: keyvals}
+}
+
+func (l *recordingLogger) last() *logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(*l.entries) == 0 {
+		return nil
+	}
+
+	return (*l.entries)[len(*l.entries)-1]
+}
+
+func TestOnUpload(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.txt")
+	logger := newRecordingLogger()
+	runner := NewRunner(nil, logger)
+
+	hook := runner.OnUpload(&confpar.Access{
+		Fs:     "os",
+		Params: map[string]string{"basePath": "/data"},
+		OnUpload: []string{
+			"sh", "-c", `echo "$0 $FTP_USER $FTP_SIZE $FTP_LOCAL_PATH" >> "$1"`, "{path}", output,
+		},
+	})
+
+	// Only the uploads run the command, including the empty ones
+	hook.Emit(&events.Event{Type: events.Delete, User: "user", Path: "/deleted.txt"})
+	hook.Emit(&events.Event{Type: events.Upload, User: "user", Path: "/dir/file.txt"})
+	runner.Wait()
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "/dir/file.txt user 0 /data/dir/file.txt\n" {
+		t.Fatal("Unexpected output:", string(content))
+	}
+
+	if entry := logger.last(); entry.event != "Hook completed" || entry.fields["exitCode"] != 0 {
+		t.Fatal("Unexpected log:", entry)
+	}
+}
+
+func TestOnUploadFailure(t *testing.T) {
+	logger := newRecordingLogger()
+	runner := NewRunner(&confpar.Hooks{Timeout: 100 * time.Millisecond}, logger)
+
+	failing := runner.OnUpload(&confpar.Access{OnUpload: []string{"sh", "-c", "echo oops; exit 3"}})
+	failing.Emit(&events.Event{Type: events.Upload, Path: "/file.txt"})
+	runner.Wait()
+
+	entry := logger.last()
+	if entry.level != "warn" || entry.event != "Hook failed" || entry.fields["exitCode"] != 3 ||
+		!strings.Contains(entry.fields["output"].(string), "oops") {
+		t.Fatal("Unexpected log:", entry)
+	}
+
+	slow := runner.OnUpload(&confpar.Access{OnUpload: []string{"sleep", "10"}})
+	start := time.Now()
+
+	slow.Emit(&events.Event{Type: events.Upload, Path: "/file.txt"})
+	runner.Wait()
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("The command wasn't killed")
+	}
+
+	if entry = logger.last(); entry.level != "error" || entry.event != "Hook timed out" {
+		t.Fatal("Unexpected log:", entry)
+	}
+}
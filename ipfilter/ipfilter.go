@@ -0,0 +1,104 @@
+// Package ipfilter matches the remote IPs against lists of networks
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrIPNotAllowed is returned when an IP is denied or isn't allowed
+var ErrIPNotAllowed = errors.New("IP not allowed")
+
+// InvalidCIDRError is returned when a network can't be parsed
+type InvalidCIDRError struct {
+	error
+	CIDR string
+}
+
+func (err InvalidCIDRError) Error() string {
+	return fmt.Sprintf("Invalid CIDR: %s", err.CIDR)
+}
+
+// List is a list of networks
+type List []*net.IPNet
+
+// Parse parses a list of CIDRs, single IPs are accepted as well
+func Parse(cidrs []string) (List, error) {
+	list := make(List, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 8*net.IPv4len
+				}
+
+				list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+				continue
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, &InvalidCIDRError{CIDR: cidr}
+		}
+
+		list = append(list, network)
+	}
+
+	return list, nil
+}
+
+// Contains tells if an IP belongs to one of the networks
+func (l List) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range l {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter allows the IPs of an allowlist, or all of them if it's empty, except the ones of a denylist
+type Filter struct {
+	Allowed List
+	Denied  List
+}
+
+// NewFilter parses the allowed and denied CIDRs
+func NewFilter(allowed, denied []string) (*Filter, error) {
+	allowedList, err := Parse(allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	deniedList, err := Parse(denied)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{Allowed: allowedList, Denied: deniedList}, nil
+}
+
+// Check returns ErrIPNotAllowed if the IP is denied or isn't allowed
+func (f *Filter) Check(ip string) error {
+	if f == nil {
+		return nil
+	}
+
+	if f.Denied.Contains(ip) || (len(f.Allowed) > 0 && !f.Allowed.Contains(ip)) {
+		return ErrIPNotAllowed
+	}
+
+	return nil
+}
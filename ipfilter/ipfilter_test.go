@@ -0,0 +1,48 @@
+package ipfilter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	list, err := Parse([]string{"192.168.1.0/24", "10.0.0.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"192.168.1.20": true,
+		"192.168.2.20": false,
+		"10.0.0.1":     true,
+		"10.0.0.2":     false,
+		"2001:db8::1":  true,
+		"invalid":      false,
+	} {
+		if list.Contains(ip) != expected {
+			t.Errorf("Unexpected result for %s", ip)
+		}
+	}
+
+	var errCIDR *InvalidCIDRError
+	if _, err := Parse([]string{"10.0.0.0/33"}); !errors.As(err, &errCIDR) {
+		t.Fatal("The CIDR should be rejected:", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter([]string{"192.168.0.0/16"}, []string{"192.168.1.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filter.Check("192.168.2.1") != nil {
+		t.Fatal("The IP should be allowed")
+	}
+
+	for _, ip := range []string{"192.168.1.1", "10.0.0.1"} {
+		if !errors.Is(filter.Check(ip), ErrIPNotAllowed) {
+			t.Fatal("The IP shouldn't be allowed:", ip)
+		}
+	}
+}
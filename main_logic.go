@@ -135,6 +135,16 @@ func runServer(confFile string, onlyConf bool, logger log.Logger) error {
 		return nil
 	}
 
+	if err := driver.ServeMetrics(); err != nil {
+		logger.Error("Problem serving metrics", "err", err)
+		return err
+	}
+
+	if err := driver.ServeAdmin(); err != nil {
+		logger.Error("Problem serving admin API", "err", err)
+		return err
+	}
+
 	if err := ftpServer.ListenAndServe(); err != nil {
 		logger.Error("Problem listening", "err", err)
 		return err
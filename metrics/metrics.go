@@ -0,0 +1,131 @@
+// Package metrics provides the Prometheus metrics of the server
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ftpserver"
+
+// Transfer directions
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Metrics holds all the collectors of the server
+type Metrics struct {
+	registry         *prometheus.Registry
+	logins           *prometheus.CounterVec
+	transferBytes    *prometheus.CounterVec
+	transferDuration *prometheus.HistogramVec
+	backendDuration  *prometheus.HistogramVec
+	backendErrors    *prometheus.CounterVec
+}
+
+// New creates the metrics, clients is called to get the number of connected clients
+func New(clients func() float64) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Number of login attempts per authentication source and result.",
+		}, []string{"source", "result"}),
+		transferBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfer_bytes_total",
+			Help:      "Number of bytes transferred per direction, access and backend.",
+		}, []string{"direction", "access", "fs"}),
+		transferDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "transfer_duration_seconds",
+			Help:      "Duration of the file transfers per direction and backend.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10), //nolint: gomnd
+		}, []string{"direction", "fs"}),
+		backendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_operation_duration_seconds",
+			Help:      "Duration of the backend operations per backend and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"fs", "operation"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Number of failed backend operations per backend and operation.",
+		}, []string{"fs", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "clients_connected",
+			Help:      "Number of connected clients.",
+		}, clients),
+		m.logins,
+		m.transferBytes,
+		m.transferDuration,
+		m.backendDuration,
+		m.backendErrors,
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Gatherer returns the registry of the metrics
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// ObserveLogin counts a login attempt
+func (m *Metrics) ObserveLogin(source string, err error) {
+	if m == nil {
+		return
+	}
+
+	// No authenticator knew the user
+	if source == "" {
+		source = "none"
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	m.logins.WithLabelValues(source, result).Inc()
+}
+
+// TransferBytes returns the counter of transferred bytes
+func (m *Metrics) TransferBytes(direction, access, fsType string) prometheus.Counter {
+	return m.transferBytes.WithLabelValues(direction, access, fsType)
+}
+
+// ObserveTransfer records the duration of a file transfer
+func (m *Metrics) ObserveTransfer(direction, fsType string, duration time.Duration) {
+	m.transferDuration.WithLabelValues(direction, fsType).Observe(duration.Seconds())
+}
+
+// ObserveOperation records the duration and the result of a backend operation
+func (m *Metrics) ObserveOperation(fsType, operation string, start time.Time, err error) {
+	m.backendDuration.WithLabelValues(fsType, operation).Observe(time.Since(start).Seconds())
+
+	// Missing files are a normal outcome of many operations (like checking a file before uploading it)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.backendErrors.WithLabelValues(fsType, operation).Inc()
+	}
+}
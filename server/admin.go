@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrUnknownSession is returned when a client isn't connected
+var ErrUnknownSession = errors.New("unknown session")
+
+// ErrMissingAdminToken is returned when the admin API is enabled without a token
+var ErrMissingAdminToken = errors.New("admin_api token must be specified")
+
+// ErrInvalidToken is returned when an admin API request doesn't provide the expected token
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrInvalidIP is returned when an IP can't be parsed
+var ErrInvalidIP = errors.New("invalid IP")
+
+const adminReadHeaderTimeout = 10 * time.Second
+
+// BlockedIP describes an IP blocked by an operator
+type BlockedIP struct {
+	IP        string    `json:"ip"`         // Blocked IP
+	BlockedAt time.Time `json:"blocked_at"` // Time of the block
+}
+
+// BlockIP rejects all new connections from an IP and disconnects its current sessions
+func (s *Server) BlockIP(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ErrInvalidIP
+	}
+
+	ip = parsed.String()
+
+	s.nbClientsSync.Lock()
+	s.blockedIPs[ip] = time.Now()
+
+	kicked := make([]*session, 0)
+
+	for _, sess := range s.sessions {
+		if remoteIP(sess.cc.RemoteAddr()) == ip {
+			kicked = append(kicked, sess)
+		}
+	}
+	s.nbClientsSync.Unlock()
+
+	s.logger.Info("Blocked IP", "ip", ip, "nbKicked", len(kicked))
+
+	// Closing the connection calls ClientDisconnected, which needs the lock
+	for _, sess := range kicked {
+		if err := sess.cc.Close(); err != nil {
+			s.logger.Warn("Problem kicking client", "clientId", sess.cc.ID(), "err", err)
+		}
+	}
+
+	return nil
+}
+
+// UnblockIP allows again the connections from an IP
+func (s *Server) UnblockIP(ip string) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	delete(s.blockedIPs, ip)
+	s.logger.Info("Unblocked IP", "ip", ip)
+}
+
+// BlockedIPs returns the IPs blocked by an operator
+func (s *Server) BlockedIPs() []*BlockedIP {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	blocked := make([]*BlockedIP, 0, len(s.blockedIPs))
+	for ip, blockedAt := range s.blockedIPs {
+		blocked = append(blocked, &BlockedIP{IP: ip, BlockedAt: blockedAt})
+	}
+
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].IP < blocked[j].IP })
+
+	return blocked
+}
+
+// ServeAdmin starts the admin HTTP API, if enabled. It's not a blocking call.
+func (s *Server) ServeAdmin() error {
+	conf := s.config.Content.AdminAPI
+	if conf == nil {
+		return nil
+	}
+
+	if conf.Token == "" {
+		return ErrMissingAdminToken
+	}
+
+	listener, err := net.Listen("tcp", conf.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	s.adminServer = &http.Server{
+		Handler:           s.adminHandler(conf.Token),
+		ReadHeaderTimeout: adminReadHeaderTimeout,
+	}
+
+	s.logger.Info("Serving admin API", "address", listener.Addr())
+
+	go func() {
+		if err := s.adminServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Problem serving admin API", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.Sessions())
+	})
+
+	mux.HandleFunc("DELETE /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		clientID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err := s.KickSession(uint32(clientID)); errors.Is(err, ErrUnknownSession) {
+			writeError(w, http.StatusNotFound, err)
+
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/blocked_ips", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.BlockedIPs())
+	})
+
+	mux.HandleFunc("POST /api/blocked_ips", func(w http.ResponseWriter, r *http.Request) {
+		var blocked BlockedIP
+		if err := json.NewDecoder(r.Body).Decode(&blocked); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		if err := s.BlockIP(blocked.IP); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /api/blocked_ips/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		s.UnblockIP(r.PathValue("ip"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/bans", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, s.guard.Bans())
+	})
+
+	mux.HandleFunc("DELETE /api/bans/{key}", func(w http.ResponseWriter, r *http.Request) {
+		s.guard.Unban(r.PathValue("key"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r.Header.Get("Authorization"), token) {
+			writeError(w, http.StatusUnauthorized, ErrInvalidToken)
+
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validToken checks the Authorization header of a request. The digests are compared, so that the time taken doesn't
+// depend on the provided value, including its length.
+func validToken(header, token string) bool {
+	provided := sha256.Sum256([]byte(header))
+	expected := sha256.Sum256([]byte("Bearer " + token))
+
+	return subtle.ConstantTimeCompare(provided[:], expected[:]) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// adminRequest sends a request to the admin API and returns the status of the response
+func adminRequest(handler http.Handler, method, path, token, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder.Code
+}
+
+func TestAdminToken(t *testing.T) {
+	server, _ := startServer(t, &confpar.Content{})
+	handler := server.adminHandler("secret")
+
+	for token, expected := range map[string]int{
+		"":        http.StatusUnauthorized,
+		"wrong":   http.StatusUnauthorized,
+		"secre":   http.StatusUnauthorized,
+		"secret2": http.StatusUnauthorized,
+		"secret":  http.StatusOK,
+	} {
+		if status := adminRequest(handler, http.MethodGet, "/api/sessions", token, ""); status != expected {
+			t.Fatalf("Unexpected status for %q: %d", token, status)
+		}
+	}
+
+	if validToken("secret", "secret") || validToken("bearer secret", "secret") || !validToken("Bearer secret", "secret") {
+		t.Fatal("Only the exact header should be accepted")
+	}
+}
+
+func TestAdminKick(t *testing.T) {
+	server, addr := startServer(t, &confpar.Content{})
+	handler := server.adminHandler("secret")
+
+	kicked, _ := dial(t, addr)
+	other, _ := dial(t, addr)
+
+	waitFor(t, func() bool { return len(server.Sessions()) == 2 })
+
+	clientID := server.Sessions()[0].ID
+
+	path := "/api/sessions/" + strconv.FormatUint(uint64(clientID), 10)
+	if status := adminRequest(handler, http.MethodDelete, path, "secret", ""); status != http.StatusNoContent {
+		t.Fatal("Unexpected status:", status)
+	}
+
+	// The first session is closed, not the other one
+	if _, err := kicked.ReadLine(); !errors.Is(err, io.EOF) {
+		t.Fatal("The session wasn't closed:", err)
+	}
+
+	waitFor(t, func() bool { return len(server.Sessions()) == 1 })
+
+	if server.Sessions()[0].ID == clientID {
+		t.Fatal("The wrong session was closed")
+	}
+
+	if _, err := other.Cmd("NOOP"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := adminRequest(handler, http.MethodDelete, path, "secret", ""); status != http.StatusNotFound {
+		t.Fatal("Unexpected status:", status)
+	}
+}
+
+func TestAdminBlockIP(t *testing.T) {
+	server, addr := startServer(t, &confpar.Content{})
+	handler := server.adminHandler("secret")
+
+	connected, _ := dial(t, addr)
+
+	waitFor(t, func() bool { return len(server.Sessions()) == 1 })
+
+	status := adminRequest(handler, http.MethodPost, "/api/blocked_ips", "secret", `{"ip": "127.0.0.1"}`)
+	if status != http.StatusNoContent {
+		t.Fatal("Unexpected status:", status)
+	}
+
+	// The current sessions are closed and the new ones refused
+	if _, err := connected.ReadLine(); !errors.Is(err, io.EOF) {
+		t.Fatal("The session wasn't closed:", err)
+	}
+
+	if _, code := dial(t, addr); code != serverlib.StatusServiceNotAvailable {
+		t.Fatal("The IP wasn't blocked:", code)
+	}
+
+	status = adminRequest(handler, http.MethodDelete, "/api/blocked_ips/127.0.0.1", "secret", "")
+	if status != http.StatusNoContent {
+		t.Fatal("Unexpected status:", status)
+	}
+
+	if _, code := dial(t, addr); code != serverlib.StatusServiceReady {
+		t.Fatal("The IP wasn't unblocked:", code)
+	}
+}
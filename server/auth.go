@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/auth/htpasswd"
+	"github.com/fclairamb/ftpserver/auth/ldap"
+	"github.com/fclairamb/ftpserver/auth/sqldb"
+	"github.com/fclairamb/ftpserver/auth/webhook"
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// Supported authenticator types
+const (
+	authStatic   = "static"
+	authWebhook  = "webhook"
+	authLDAP     = "ldap"
+	authHtpasswd = "htpasswd"
+	authSQL      = "sql"
+)
+
+// authCertificate is the login source of the users authenticated by their client certificate
+const authCertificate = "certificate"
+
+// UnsupportedAuthenticatorError is returned when the described authenticator is not supported
+type UnsupportedAuthenticatorError struct {
+	error
+	Type string
+}
+
+func (err UnsupportedAuthenticatorError) Error() string {
+	return fmt.Sprintf("Unsupported authenticator: %s", err.Type)
+}
+
+// MissingAuthenticatorConfigError is returned when an authenticator is used without its config section
+type MissingAuthenticatorConfigError struct {
+	error
+	Type string
+}
+
+func (err MissingAuthenticatorConfigError) Error() string {
+	return fmt.Sprintf("Missing config for authenticator: %s", err.Type)
+}
+
+// defaultAuthenticators is the chain used when none is configured: the ldap directory, the webhook and the sql
+// database are tried in this order when they're configured
+func defaultAuthenticators(conf *confpar.Content) []*confpar.Authenticator {
+	var links []*confpar.Authenticator
+
+	if conf.LDAP != nil {
+		links = append(links, &confpar.Authenticator{Type: authLDAP})
+	}
+
+	if conf.AccessesWebhook != nil {
+		links = append(links, &confpar.Authenticator{Type: authWebhook})
+	}
+
+	if conf.SQL != nil {
+		links = append(links, &confpar.Authenticator{Type: authSQL})
+	}
+
+	if len(links) == 0 {
+		links = append(links, &confpar.Authenticator{Type: authStatic})
+	}
+
+	return links
+}
+
+// loadAuthenticator creates the authenticators chain described by the config
+func (s *Server) loadAuthenticator() (*auth.Chain, error) {
+	conf := s.config.Content
+
+	confLinks := conf.Authenticators
+	if len(confLinks) == 0 {
+		confLinks = defaultAuthenticators(conf)
+	}
+
+	links := make([]*auth.Link, 0, len(confLinks))
+
+	for _, confLink := range confLinks {
+		authenticator, err := s.newAuthenticator(confLink)
+		if err != nil {
+			// The authenticators already created aren't used
+			_ = auth.NewChain(s.logger, links...).Close()
+
+			return nil, err
+		}
+
+		name := confLink.Name
+		if name == "" {
+			name = confLink.Type
+		}
+
+		links = append(links, &auth.Link{Name: name, Authenticator: authenticator})
+	}
+
+	return auth.NewChain(s.logger.With("component", "auth"), links...), nil
+}
+
+func (s *Server) newAuthenticator(link *confpar.Authenticator) (auth.Authenticator, error) {
+	conf := s.config.Content
+
+	switch link.Type {
+	case authStatic:
+		return auth.Func(func(cc serverlib.ClientContext, user, pass string) (*confpar.Access, error) {
+			access, err := s.config.GetAccess(user, pass, remoteIP(cc.RemoteAddr()))
+			if errors.Is(err, config.ErrUnknownUser) {
+				return nil, auth.ErrUnknownUser
+			}
+
+			return access, err
+		}), nil
+	case authWebhook:
+		if conf.AccessesWebhook == nil {
+			return nil, &MissingAuthenticatorConfigError{Type: link.Type}
+		}
+
+		return webhook.New(conf.AccessesWebhook, s.logger.With("component", "webhook"))
+	case authLDAP:
+		if conf.LDAP == nil {
+			return nil, &MissingAuthenticatorConfigError{Type: link.Type}
+		}
+
+		return ldap.New(conf.LDAP, s.logger.With("component", "ldap"))
+	case authHtpasswd:
+		return htpasswd.New(link.File, link.Access, s.logger.With("component", "htpasswd"))
+	case authSQL:
+		if conf.SQL == nil {
+			return nil, &MissingAuthenticatorConfigError{Type: link.Type}
+		}
+
+		return sqldb.New(conf.SQL, s.logger.With("component", "sql"))
+	default:
+		return nil, &UnsupportedAuthenticatorError{Type: link.Type}
+	}
+}
+
+// isRejectedCredentials tells if an authentication error comes from wrong credentials, and not from an unavailable
+// backend or a policy of the access
+func isRejectedCredentials(err error) bool {
+	for _, rejected := range []error{
+		auth.ErrUnknownUser,
+		config.ErrInvalidPassword,
+		htpasswd.ErrInvalidPassword,
+		ldap.ErrInvalidCredentials,
+		sqldb.ErrInvalidPassword,
+		webhook.ErrRejected,
+	} {
+		if errors.Is(err, rejected) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) getAuthenticator() *auth.Chain {
+	s.authSync.RLock()
+	defer s.authSync.RUnlock()
+
+	return s.authenticator
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/ipfilter"
+)
+
+// ErrTooManyClients is returned when the max_clients limit is reached
+var ErrTooManyClients = errors.New("too many clients")
+
+// ErrTooManySessionsPerIP is returned when the max_sessions_per_ip limit is reached
+var ErrTooManySessionsPerIP = errors.New("too many sessions from this IP")
+
+// ErrTooManySessionsPerUser is returned when the max_sessions limit of an access is reached
+var ErrTooManySessionsPerUser = errors.New("too many sessions for this user")
+
+// ErrIPBlocked is returned when the IP was blocked by an operator
+var ErrIPBlocked = errors.New("IP blocked")
+
+// rejectTimeout is the max time we spend sending the 421 reply to a rejected client
+const rejectTimeout = 5 * time.Second
+
+// limitedListener rejects the connections that would exceed the configured limits
+// with a 421 reply, before they reach the FTP server
+type limitedListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept waits for the next admitted connection
+func (l *limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if errAdmit := l.server.admitClient(conn.RemoteAddr()); errAdmit != nil {
+			l.server.logger.Warn(
+				"Client rejected",
+				"remoteAddr", conn.RemoteAddr(),
+				"err", errAdmit,
+			)
+
+			// Writing to an implicit TLS connection triggers the handshake, we don't want to block the accept loop
+			go rejectConn(conn, errAdmit)
+
+			continue
+		}
+
+		return conn, nil
+	}
+}
+
+func rejectConn(conn net.Conn, reason error) {
+	_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+	_, _ = fmt.Fprintf(conn, "%d %s\r\n", serverlib.StatusServiceNotAvailable, reason)
+	_ = conn.Close()
+}
+
+func (s *Server) createListener(tlsRequired serverlib.TLSRequirement) (net.Listener, error) {
+	listener, err := net.Listen("tcp", s.config.Content.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsRequired == serverlib.ImplicitEncryption {
+		if _, errTLS := s.GetTLSConfig(); errTLS != nil {
+			_ = listener.Close()
+
+			return nil, fmt.Errorf("cannot get tls config: %w", errTLS)
+		}
+
+		// The listener keeps its config, the reloaded settings are fetched for each connection
+		listener = tls.NewListener(listener, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.GetTLSConfig()
+			},
+		})
+	}
+
+	return &limitedListener{Listener: listener, server: s}, nil
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// checkAccessIP checks that an access can be used from the IP of a client
+func checkAccessIP(access *confpar.Access, cc serverlib.ClientContext) error {
+	filter, err := ipfilter.NewFilter(access.AllowedIPs, access.DeniedIPs)
+	if err != nil {
+		return err
+	}
+
+	return filter.Check(remoteIP(cc.RemoteAddr()))
+}
+
+// admitClient reserves a client slot, the slot is released in ClientDisconnected
+func (s *Server) admitClient(addr net.Addr) error {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	conf := s.config.Content
+	ip := remoteIP(addr)
+
+	if _, blocked := s.blockedIPs[ip]; blocked {
+		return ErrIPBlocked
+	}
+
+	if err := s.ipFilter.Check(ip); err != nil {
+		return err
+	}
+
+	if err := s.guard.Check(ip, ""); err != nil {
+		return err
+	}
+
+	if conf.MaxClients > 0 && int(s.nbClients) >= conf.MaxClients {
+		return ErrTooManyClients
+	}
+
+	if conf.MaxSessionsPerIP > 0 && s.ipSessions[ip] >= conf.MaxSessionsPerIP {
+		return ErrTooManySessionsPerIP
+	}
+
+	s.nbClients++
+	s.ipSessions[ip]++
+
+	return nil
+}
+
+// releaseClient frees the slots taken by a client. It must be called with nbClientsSync held.
+func (s *Server) releaseClient(cc serverlib.ClientContext) {
+	s.nbClients--
+
+	ip := remoteIP(cc.RemoteAddr())
+	if s.ipSessions[ip]--; s.ipSessions[ip] <= 0 {
+		delete(s.ipSessions, ip)
+	}
+
+	if sess := s.sessions[cc.ID()]; sess != nil {
+		sess.cancel()
+		s.releaseUserSession(sess)
+		delete(s.sessions, cc.ID())
+	}
+}
+
+// openUserSession accounts an authenticated session against its access
+func (s *Server) openUserSession(cc serverlib.ClientContext, user string, access *confpar.Access) (*session, error) {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	sess := s.sessions[cc.ID()]
+	if sess == nil {
+		return nil, ErrUnknownSession
+	}
+
+	// A client can authenticate more than once on the same connection
+	s.releaseUserSession(sess)
+
+	if access.MaxSessions > 0 && s.userSessions[access.User] >= access.MaxSessions {
+		return nil, ErrTooManySessionsPerUser
+	}
+
+	s.userSessions[access.User]++
+	sess.throttle = s.throttler.Acquire(sess.ctx, remoteIP(cc.RemoteAddr()), access)
+	sess.user = user
+	sess.access = access.User
+	sess.fs = access.Fs
+
+	return sess, nil
+}
+
+// releaseUserSession must be called with nbClientsSync held
+func (s *Server) releaseUserSession(sess *session) {
+	if sess.access == "" {
+		return
+	}
+
+	if s.userSessions[sess.access]--; s.userSessions[sess.access] <= 0 {
+		delete(s.userSessions, sess.access)
+	}
+
+	sess.throttle.Release()
+	sess.user, sess.access, sess.fs, sess.throttle = "", "", "", nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+func TestMaxClients(t *testing.T) {
+	server, addr := startServer(t, &confpar.Content{MaxClients: 2})
+
+	first, code := dial(t, addr)
+	if code != serverlib.StatusServiceReady {
+		t.Fatal("Unexpected reply:", code)
+	}
+
+	if _, code = dial(t, addr); code != serverlib.StatusServiceReady {
+		t.Fatal("Unexpected reply:", code)
+	}
+
+	if _, code = dial(t, addr); code != serverlib.StatusServiceNotAvailable {
+		t.Fatal("Unexpected reply:", code)
+	}
+
+	_ = first.Close()
+
+	waitFor(t, func() bool {
+		server.nbClientsSync.Lock()
+		defer server.nbClientsSync.Unlock()
+
+		return server.nbClients < 2
+	})
+
+	if _, code = dial(t, addr); code != serverlib.StatusServiceReady {
+		t.Fatal("The slot wasn't released:", code)
+	}
+}
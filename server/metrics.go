@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+const (
+	defaultMetricsPath       = "/metrics"
+	metricsReadHeaderTimeout = 10 * time.Second
+)
+
+// loadMetrics creates the metrics if they are enabled
+func (s *Server) loadMetrics() *metrics.Metrics {
+	if s.config.Content.Metrics == nil {
+		return nil
+	}
+
+	return metrics.New(func() float64 {
+		s.nbClientsSync.Lock()
+		defer s.nbClientsSync.Unlock()
+
+		return float64(s.nbClients)
+	})
+}
+
+// ServeMetrics starts the metrics HTTP endpoint, if enabled. It's not a blocking call.
+func (s *Server) ServeMetrics() error {
+	conf := s.config.Content.Metrics
+	if s.metrics == nil || conf == nil {
+		return nil
+	}
+
+	path := conf.Path
+	if path == "" {
+		path = defaultMetricsPath
+	}
+
+	listener, err := net.Listen("tcp", conf.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, s.metrics.Handler())
+
+	s.metricsServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+	}
+
+	s.logger.Info("Serving metrics", "address", listener.Addr(), "path", path)
+
+	go func() {
+		if err := s.metricsServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Problem serving metrics", "err", err)
+		}
+	}()
+
+	return nil
+}
@@ -2,16 +2,15 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/spf13/afero"
@@ -19,10 +18,21 @@ import (
 	serverlib "github.com/fclairamb/ftpserverlib"
 	log "github.com/fclairamb/go-log"
 
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/bruteforce"
+	"github.com/fclairamb/ftpserver/certs"
 	"github.com/fclairamb/ftpserver/config"
 	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/events"
 	"github.com/fclairamb/ftpserver/fs"
+	"github.com/fclairamb/ftpserver/fs/fsevents"
 	"github.com/fclairamb/ftpserver/fs/fslog"
+	"github.com/fclairamb/ftpserver/fs/fsmetrics"
+	"github.com/fclairamb/ftpserver/fs/fsquota"
+	"github.com/fclairamb/ftpserver/fs/fsthrottle"
+	"github.com/fclairamb/ftpserver/hooks"
+	"github.com/fclairamb/ftpserver/ipfilter"
+	"github.com/fclairamb/ftpserver/metrics"
 )
 
 // Server structure
@@ -31,11 +41,28 @@ type Server struct { // nolint: maligned
 	logger          log.Logger
 	nbClients       uint32
 	nbClientsSync   sync.Mutex
+	ipSessions      map[string]int       // Number of sessions per remote IP
+	userSessions    map[string]int       // Number of sessions per access
+	sessions        map[uint32]*session  // Connected clients
+	blockedIPs      map[string]time.Time // IPs blocked by an operator
+	ipFilter        *ipfilter.Filter     // IPs allowed to connect
 	zeroClientEvent chan error
 	tlsOnce         sync.Once
-	tlsConfig       *tls.Config
+	tlsConfig       atomic.Pointer[tls.Config] // Swapped when the TLS settings are reloaded
 	tlsError        error
+	keyPairs        *certs.KeyPairs // Certificates loaded from files
+	acmeConf        *confpar.ACME   // ACME settings in use, they can't be reloaded
+	guard           *bruteforce.Guard
 	accesses        *fsCache
+	authenticator   *auth.Chain
+	authSync        sync.RWMutex
+	metrics         *metrics.Metrics
+	metricsServer   *http.Server
+	adminServer     *http.Server
+	events          *events.Notifier
+	hooks           *hooks.Runner
+	quotas          *fsquota.Registry
+	throttler       *fsthrottle.Throttler
 }
 
 type fsCache struct {
@@ -55,16 +82,52 @@ var ErrTimeout = errors.New("timeout")
 // ErrNotImplemented is returned when we're using something that has not been implemented yet
 // var ErrNotImplemented = errors.New("not implemented")
 
+// ErrTLSRequired is returned when a user requiring TLS logs in on a cleartext control connection
+var ErrTLSRequired = errors.New("TLS is required for this user, use AUTH TLS")
+
+// ErrAuthenticationFailed is replied to the clients whose authentication failed, the actual reason is only logged
+var ErrAuthenticationFailed = errors.New("invalid credentials")
+
 // ErrNotEnabled is returned when a feature hasn't been enabled
 var ErrNotEnabled = errors.New("not enabled")
 
 // NewServer creates a server instance
 func NewServer(config *config.Config, logger log.Logger) (*Server, error) {
-	return &Server{
-		config:   config,
-		logger:   logger,
-		accesses: newFsCache(),
-	}, nil
+	s := &Server{
+		config:       config,
+		logger:       logger,
+		accesses:     newFsCache(),
+		ipSessions:   make(map[string]int),
+		userSessions: make(map[string]int),
+		sessions:     make(map[uint32]*session),
+		blockedIPs:   make(map[string]time.Time),
+		quotas:       fsquota.NewRegistry(config.Content.QuotaDir, logger.With("component", "quota")),
+		throttler:    fsthrottle.NewThrottler(config.Content.Bandwidth, config.Content.BandwidthPerIP),
+	}
+
+	var err error
+	if s.authenticator, err = s.loadAuthenticator(); err != nil {
+		return nil, err
+	}
+
+	if s.ipFilter, err = ipfilter.NewFilter(config.Content.AllowedIPs, config.Content.DeniedIPs); err != nil {
+		return nil, err
+	}
+
+	if s.guard, err = bruteforce.NewGuard(config.Content.BruteForce, logger.With("component", "bruteforce")); err != nil {
+		return nil, err
+	}
+
+	s.metrics = s.loadMetrics()
+	s.hooks = hooks.NewRunner(config.Content.Hooks, logger.With("component", "hooks"))
+
+	if conf := config.Content.EventsWebhook; conf != nil {
+		if s.events, err = events.NewNotifier(conf, logger.With("component", "events")); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
 // GetSettings returns some general settings around the server setup
@@ -90,22 +153,87 @@ func (s *Server) GetSettings() (*serverlib.Settings, error) {
 		tlsRequired = serverlib.ClearOrEncrypted
 	}
 
+	// The certificate files and the ACME settings are checked at startup. The ACME certificate is obtained in the
+	// background, the TLS connections fail until it is.
+	if tlsEnabled(conf.TLS) {
+		if _, err := s.GetTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	// We provide our own listener to enforce the connection limits
+	listener, err := s.createListener(tlsRequired)
+	if err != nil {
+		return nil, err
+	}
+
 	return &serverlib.Settings{
+		Listener:                 listener,
 		ListenAddr:               conf.ListenAddress,
 		PublicHost:               conf.PublicHost,
 		PassiveTransferPortRange: portRange,
 		TLSRequired:              tlsRequired,
 	}, nil
 }
+
+// ReloadConfig reloads the config file, the authentication layer, the transfer rates and the TLS settings
 func (s *Server) ReloadConfig() error {
-	return s.config.Load()
+	if err := s.config.Load(); err != nil {
+		return err
+	}
+
+	ipFilter, err := ipfilter.NewFilter(s.config.Content.AllowedIPs, s.config.Content.DeniedIPs)
+	if err != nil {
+		return err
+	}
+
+	s.nbClientsSync.Lock()
+	s.ipFilter = ipFilter
+	s.nbClientsSync.Unlock()
+
+	if err := s.guard.Update(s.config.Content.BruteForce); err != nil {
+		return err
+	}
+
+	s.throttler.Update(s.config.Content.Bandwidth, s.config.Content.BandwidthPerIP)
+
+	// The sessions of the accesses and templates of the config file are updated, the other ones at their next login
+	for _, access := range s.config.Content.Accesses {
+		s.throttler.UpdateTemplate(access.User, access.Bandwidth)
+	}
+
+	if ldapConf := s.config.Content.LDAP; ldapConf != nil {
+		for _, group := range ldapConf.Groups {
+			if group.Access != nil {
+				s.throttler.UpdateTemplate(group.Group, group.Access.Bandwidth)
+			}
+		}
+	}
+
+	authenticator, err := s.loadAuthenticator()
+	if err != nil {
+		return err
+	}
+
+	s.authSync.Lock()
+	previous := s.authenticator
+	s.authenticator = authenticator
+	s.authSync.Unlock()
+
+	if err := previous.Close(); err != nil {
+		s.logger.Warn("Problem closing the previous authenticators", "err", err)
+	}
+
+	return s.reloadTLS()
 }
 
 // ClientConnected is called to send the very first welcome message
+// The client slot has already been reserved by the listener.
 func (s *Server) ClientConnected(cc serverlib.ClientContext) (string, error) {
 	s.nbClientsSync.Lock()
 	defer s.nbClientsSync.Unlock()
-	s.nbClients++
+	ctx, cancel := context.WithCancel(context.Background())
+	s.sessions[cc.ID()] = &session{cc: cc, connected: time.Now(), ctx: ctx, cancel: cancel}
 	s.logger.Info(
 		"Client connected",
 		"clientId", cc.ID(),
@@ -125,7 +253,7 @@ func (s *Server) ClientDisconnected(cc serverlib.ClientContext) {
 	s.nbClientsSync.Lock()
 	defer s.nbClientsSync.Unlock()
 
-	s.nbClients--
+	s.releaseClient(cc)
 
 	s.logger.Info(
 		"Client disconnected",
@@ -142,13 +270,28 @@ func (s *Server) Stop() {
 	defer s.nbClientsSync.Unlock()
 	s.zeroClientEvent = make(chan error, 1)
 	s.considerEnd()
+
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Close(); err != nil {
+			s.logger.Warn("Problem stopping metrics server", "err", err)
+		}
+	}
+
+	if s.adminServer != nil {
+		if err := s.adminServer.Close(); err != nil {
+			s.logger.Warn("Problem stopping admin server", "err", err)
+		}
+	}
 }
 
-// WaitGracefully allows to gracefully wait for all currently connected clients before disconnecting
+// WaitGracefully allows to gracefully wait for all currently connected clients before disconnecting.
+// The running hooks are then waited for and the events notifier is stopped.
 func (s *Server) WaitGracefully(timeout time.Duration) error {
 	s.logger.Info("Waiting for last client to disconnect...")
 
 	defer func() { s.zeroClientEvent = nil }()
+	defer s.events.Stop()
+	defer s.hooks.Wait()
 
 	select {
 	case err := <-s.zeroClientEvent:
@@ -176,7 +319,7 @@ func (s *Server) loadFs(access *confpar.Access) (afero.Fs, error) {
 		return cachedFs, nil
 	}
 
-	newFs, err := fs.LoadFs(access, s.logger)
+	newFs, err := fs.LoadFs(access, s.logger, s.quotas)
 	if err != nil {
 		return nil, err
 	}
@@ -188,83 +331,174 @@ func (s *Server) loadFs(access *confpar.Access) (afero.Fs, error) {
 	return newFs, err
 }
 
-func (s *Server) getAccessFromWebhook(user, pass string) (*confpar.Access, error) {
-	// Convert payload to JSON
-	jsonData, err := json.Marshal(map[string]string{
-		"user": user,
-		"pass": pass,
-	})
-	if err != nil {
+// AuthUser authenticates the user and selects an handling driver
+func (s *Server) AuthUser(cc serverlib.ClientContext, user, pass string) (serverlib.ClientDriver, error) {
+	if err := s.guard.Check(remoteIP(cc.RemoteAddr()), user); err != nil {
+		s.logAuthFailure(cc, user, err)
+
 		return nil, err
 	}
 
-	// Timeout is implemented with context termination
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Content.AccessesWebhook.Timeout)
-	defer cancel()
+	access, source, errAccess := s.getAuthenticator().Resolve(cc, user, pass)
 
-	// Create a new HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", s.config.Content.AccessesWebhook.URL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
+	// The validity of the accesses is checked whatever their authenticator, including the templates and the cached
+	// webhook responses
+	if errAccess == nil {
+		errAccess = s.config.CheckAccess(user, access)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	for key, value := range s.config.Content.AccessesWebhook.Headers {
-		req.Header.Set(key, value)
+	if errAccess == nil && access.ClientCert != nil {
+		if errAccess = certs.MatchClientCert(access.ClientCert, s.getClientCert(cc)); errAccess != nil {
+			s.logger.Warn("Client certificate rejected", "clientId", cc.ID(), "user", user, "err", errAccess)
+		}
 	}
 
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if errAccess == nil {
+		errAccess = checkAccessIP(access, cc)
 	}
-	defer resp.Body.Close()
 
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if errAccess == nil && access.TLSRequired && !cc.HasTLSForControl() {
+		errAccess = ErrTLSRequired
 	}
 
-	// Return the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	s.metrics.ObserveLogin(source, errAccess)
+
+	if errAccess != nil {
+		s.logAuthFailure(cc, user, errAccess)
+
+		// Only the rejected credentials are counted, their reply is delayed to slow down password guessing
+		if isRejectedCredentials(errAccess) {
+			time.Sleep(s.guard.Failure(remoteIP(cc.RemoteAddr()), user))
+		}
+
+		// The client can't tell an unknown user from a wrong password, nor see the errors of the backends. The TLS
+		// requirement is the only policy replied as is, it's checked once the credentials are accepted and doesn't
+		// tell more than a TLS connection would. The other ones would confirm the password to a refused client.
+		if errors.Is(errAccess, ErrTLSRequired) {
+			return nil, errAccess
+		}
+
+		return nil, ErrAuthenticationFailed
 	}
 
-	access := new(confpar.Access)
-	err = json.Unmarshal(body, &access)
-	if err != nil {
-		return nil, err
+	s.guard.Success(remoteIP(cc.RemoteAddr()), user)
+
+	// The accesses which don't come from a configured access or template are named after their authenticator
+	if access.Template == "" {
+		access.Template = source
 	}
 
-	return access, nil
+	if access.TLSRequired {
+		// Transfers must be encrypted as well
+		if err := cc.SetTLSRequirement(serverlib.MandatoryEncryption); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.newClientDriver(cc, user, access)
 }
 
-// AuthUser authenticates the user and selects an handling driver
-func (s *Server) AuthUser(cc serverlib.ClientContext, user, pass string) (serverlib.ClientDriver, error) {
-	var (
-		access    *confpar.Access
-		errAccess error
+// logAuthFailure logs a failed login, the remoteIP field allows tools like fail2ban to ban the client
+func (s *Server) logAuthFailure(cc serverlib.ClientContext, user string, err error) {
+	s.logger.Warn(
+		"Authentication failed",
+		"remoteIP", remoteIP(cc.RemoteAddr()),
+		"user", user,
+		"clientId", cc.ID(),
+		"err", err,
 	)
+}
 
-	if s.config.Content.AccessesWebhook == nil {
-		// Get the access from the configuration
-		access, errAccess = s.config.GetAccess(user, pass)
-	} else {
-		// Get the access from the webhook, not the configuration
-		access, errAccess = s.getAccessFromWebhook(user, pass)
+// VerifyConnection is called when a user is announced on a TLS control connection. A verified client certificate
+// authenticates the user if an access accepts it without password, it's kept to be checked after the password
+// authentication otherwise.
+func (s *Server) VerifyConnection(cc serverlib.ClientContext, user string, tlsConn *tls.Conn) (
+	serverlib.ClientDriver, error,
+) {
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, nil //nolint:nilnil // The password is required
 	}
-	if errAccess != nil {
-		return nil, errAccess
+
+	cert := state.VerifiedChains[0][0]
+
+	sess := s.getSession(cc.ID())
+	if sess == nil {
+		return nil, ErrUnknownSession
+	}
+
+	sess.mu.Lock()
+	sess.clientCert = cert
+	sess.mu.Unlock()
+
+	access, err := s.config.GetCertAccess(user, cert, remoteIP(cc.RemoteAddr()))
+	if err != nil {
+		return nil, nil //nolint:nilnil,nilerr // The password is required
+	}
+
+	if err := checkAccessIP(access, cc); err != nil {
+		s.logAuthFailure(cc, user, err)
+
+		return nil, err
+	}
+
+	s.metrics.ObserveLogin(authCertificate, nil)
+	s.logger.Info(
+		"User authenticated by certificate",
+		"clientId", cc.ID(),
+		"user", user,
+		"subject", cert.Subject.String(),
+		"fingerprint", certs.Fingerprint(cert),
+	)
+
+	return s.newClientDriver(cc, user, access)
+}
+
+// getClientCert returns the verified certificate presented by a client, if any
+func (s *Server) getClientCert(cc serverlib.ClientContext) *x509.Certificate {
+	sess := s.getSession(cc.ID())
+	if sess == nil {
+		return nil
 	}
 
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return sess.clientCert
+}
+
+// newClientDriver creates the driver of an authenticated user
+func (s *Server) newClientDriver(cc serverlib.ClientContext, user string, access *confpar.Access) (
+	serverlib.ClientDriver, error,
+) {
 	accFs, errFs := s.loadFs(access)
 
 	if errFs != nil {
 		return nil, errFs
 	}
 
+	sess, errSession := s.openUserSession(cc, user, access)
+	if errSession != nil {
+		s.logger.Warn(
+			"Client rejected",
+			"clientId", cc.ID(),
+			"remoteAddr", cc.RemoteAddr(),
+			"user", user,
+			"err", errSession,
+		)
+
+		return nil, errSession
+	}
+
+	// The login is only counted once the session is accepted
+	if err := s.config.UseAccess(user, access); err != nil {
+		s.nbClientsSync.Lock()
+		s.releaseUserSession(sess)
+		s.nbClientsSync.Unlock()
+
+		return nil, err
+	}
+
 	if s.config.Content.Logging.FtpExchanges || access.Logging.FtpExchanges {
 		cc.SetDebug(true)
 	}
@@ -286,43 +520,201 @@ func (s *Server) AuthUser(cc serverlib.ClientContext, user, pass string) (server
 		}
 	}
 
-	return &ClientDriver{
+	accFs = &sessionFs{Fs: accFs, session: sess}
+
+	if s.metrics != nil {
+		var err error
+
+		accFs, err = fsmetrics.LoadFS(accFs, s.metrics, access.Template, access.Fs)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var emitters events.Emitters
+
+	if s.events != nil {
+		emitters = append(emitters, s.events)
+	}
+
+	if len(access.OnUpload) > 0 {
+		emitters = append(emitters, s.hooks.OnUpload(access))
+	}
+
+	if len(emitters) > 0 {
+		var err error
+
+		accFs, err = fsevents.LoadFS(accFs, emitters, &events.Event{
+			User:       user,
+			Fs:         access.Fs,
+			RemoteAddr: cc.RemoteAddr().String(),
+			ClientID:   cc.ID(),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accFs, err := fsthrottle.LoadFS(accFs, sess.throttle)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := &ClientDriver{
 		Fs: accFs,
-	}, nil
+	}
+
+	if access.Quota != nil {
+		// Shared file systems are cached, this also updates the limits after a config reload
+		driver.quota = s.quotas.Get(access, nil)
+	}
+
+	return driver, nil
 }
 
 // The ClientDriver is the internal structure used for handling the client. At this stage it's limited to the afero.Fs
 type ClientDriver struct {
 	afero.Fs
+	quota *fsquota.Usage
+}
+
+// GetAvailableSpace returns the bytes the client can still upload, for the AVBL command
+func (d *ClientDriver) GetAvailableSpace(_ string) (int64, error) {
+	if d.quota == nil {
+		return 0, ErrNotEnabled
+	}
+
+	return d.quota.Available()
 }
 
 func (s *Server) loadTLSConfig() (*tls.Config, error) {
 	tlsConf := s.config.Content.TLS
-	if tlsConf == nil || tlsConf.ServerCert == nil {
+	if !tlsEnabled(tlsConf) {
 		return nil, ErrNotEnabled
 	}
 
-	serverCert := tlsConf.ServerCert
+	// The certificate is provided through a callback so that it can be swapped
+	tlsConfig := &tls.Config{}
+
+	if err := applyTLSSettings(tlsConfig, tlsConf); err != nil {
+		return nil, err
+	}
+
+	if tlsConf.ACME != nil {
+		manager, err := s.loadACME(tlsConf.ACME)
+		if err != nil {
+			return nil, err
+		}
+
+		s.acmeConf = tlsConf.ACME
+		tlsConfig.GetCertificate = manager.GetCertificate
+	} else {
+		keyPairs, err := certs.NewKeyPairs(serverCerts(tlsConf), s.logger.With("component", "tls"))
+		if err != nil {
+			return nil, err
+		}
+
+		keyPairs.Watch(tlsConf.WatchInterval)
 
-	certBytes, errReadFileCert := ioutil.ReadFile(serverCert.Cert)
-	if errReadFileCert != nil {
-		return nil, fmt.Errorf("could not load cert file: %s: %w", serverCert.Cert, errReadFileCert)
+		s.keyPairs = keyPairs
+		tlsConfig.GetCertificate = keyPairs.GetCertificate
 	}
 
-	keyBytes, errReadFileKey := ioutil.ReadFile(serverCert.Key)
-	if errReadFileKey != nil {
-		return nil, fmt.Errorf("could not load key file: %s: %w", serverCert.Cert, errReadFileCert)
+	return tlsConfig, nil
+}
+
+// applyTLSSettings applies the TLS policy and the client CA of the config, these can be reloaded
+func applyTLSSettings(tlsConfig *tls.Config, tlsConf *confpar.TLS) error {
+	if err := certs.ApplyPolicy(tlsConfig, tlsConf); err != nil {
+		return err
 	}
 
-	keypair, errKeyPair := tls.X509KeyPair(certBytes, keyBytes)
-	if errKeyPair != nil {
-		return nil, fmt.Errorf("could not parse key pairs: %w", errKeyPair)
+	if tlsConf.ClientCA != "" {
+		pool, err := certs.LoadCertPool(tlsConf.ClientCA)
+		if err != nil {
+			return err
+		}
+
+		// The certificates are checked against the accesses once the user is known
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 	}
 
-	return &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{keypair},
-	}, nil
+	return nil
+}
+
+// tlsEnabled tells if the config provides a certificate
+func tlsEnabled(tlsConf *confpar.TLS) bool {
+	return tlsConf != nil && (len(serverCerts(tlsConf)) > 0 || tlsConf.ACME != nil)
+}
+
+// serverCerts returns the certificate files, the default one first
+func serverCerts(tlsConf *confpar.TLS) []*confpar.ServerCert {
+	var files []*confpar.ServerCert
+
+	if tlsConf.ServerCert != nil {
+		files = append(files, tlsConf.ServerCert)
+	}
+
+	return append(files, tlsConf.ServerCerts...)
+}
+
+// reloadTLS reloads the TLS settings and the certificate files, the current ones are kept if the new ones are invalid.
+// Enabling or disabling TLS and changing the ACME settings require a restart.
+func (s *Server) reloadTLS() error {
+	tlsConf := s.config.Content.TLS
+
+	// This also synchronizes with the initial loading of the certificates
+	current, err := s.GetTLSConfig()
+	if enabled := tlsEnabled(tlsConf); err != nil || !enabled {
+		if enabled == (err != nil) {
+			s.logger.Warn("TLS can only be enabled or disabled with a restart")
+		}
+
+		return nil //nolint:nilerr // TLS isn't enabled
+	}
+
+	if !reflect.DeepEqual(tlsConf.ACME, s.acmeConf) {
+		s.logger.Warn("The ACME settings can only be changed with a restart")
+	}
+
+	// The cloned config keeps the certificate callback and the session ticket keys
+	tlsConfig := current.Clone()
+	tlsConfig.CipherSuites, tlsConfig.CurvePreferences = nil, nil
+	tlsConfig.ClientCAs, tlsConfig.ClientAuth = nil, tls.NoClientCert
+
+	if err := applyTLSSettings(tlsConfig, tlsConf); err != nil {
+		return fmt.Errorf("TLS settings not reloaded: %w", err)
+	}
+
+	s.tlsConfig.Store(tlsConfig)
+
+	if s.keyPairs == nil || tlsConf.ACME != nil {
+		return nil
+	}
+
+	s.keyPairs.Watch(tlsConf.WatchInterval)
+
+	if err := s.keyPairs.Reload(serverCerts(tlsConf)); err != nil {
+		return fmt.Errorf("certificate not reloaded: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) loadACME(conf *confpar.ACME) (*certs.ACME, error) {
+	manager, err := certs.NewACME(conf, s.logger.With("component", "acme"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := manager.Start(); err != nil {
+		return nil, err
+	}
+
+	return manager, nil
 }
 
 // GetTLSConfig returns a TLS Certificate to use
@@ -331,8 +723,10 @@ func (s *Server) GetTLSConfig() (*tls.Config, error) {
 	// The function is called every single time a control or transfer connection requires a TLS connection. As such
 	// it's important to cache it.
 	s.tlsOnce.Do(func() {
-		s.tlsConfig, s.tlsError = s.loadTLSConfig()
+		tlsConfig, err := s.loadTLSConfig()
+		s.tlsConfig.Store(tlsConfig)
+		s.tlsError = err
 	})
 
-	return s.tlsConfig, s.tlsError
+	return s.tlsConfig.Load(), s.tlsError
 }
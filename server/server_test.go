@@ -0,0 +1,301 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	lognoop "github.com/fclairamb/go-log/noop"
+
+	"github.com/fclairamb/ftpserver/auth"
+	"github.com/fclairamb/ftpserver/bruteforce"
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
+)
+
+// startServer starts an FTP server on a random local port and returns its address
+func startServer(t *testing.T, content *confpar.Content) (*Server, string) {
+	t.Helper()
+
+	content.ListenAddress = "127.0.0.1:0"
+
+	conf, err := config.FromContent(content, "test.json", lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewServer(conf, lognoop.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ftpServer := serverlib.NewFtpServer(server)
+	if err := ftpServer.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = ftpServer.Serve() }()
+
+	t.Cleanup(func() { _ = ftpServer.Stop() })
+
+	return server, ftpServer.Addr()
+}
+
+// dial connects to the server and returns the status code of its first reply
+func dial(t *testing.T, addr string) (*textproto.Conn, int) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	client := textproto.NewConn(conn)
+	t.Cleanup(func() { _ = client.Close() })
+
+	code, _, err := client.ReadResponse(0)
+	if err != nil && code == 0 {
+		t.Fatal(err)
+	}
+
+	return client, code
+}
+
+// waitFor polls a condition until it's true, or fails the test after a few seconds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout")
+		}
+	}
+}
+
+func TestTLSWithoutCertificates(t *testing.T) {
+	// The TLS settings can be specified without enabling TLS
+	_, addr := startServer(t, &confpar.Content{TLS: &confpar.TLS{MinVersion: "1.3"}})
+
+	if _, code := dial(t, addr); code != serverlib.StatusServiceReady {
+		t.Fatal("Unexpected reply:", code)
+	}
+}
+
+// writeKeyPair writes a self-signed certificate and its key, and returns the file names
+func writeKeyPair(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSReload(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir())
+
+	server, _ := startServer(t, &confpar.Content{
+		TLS: &confpar.TLS{ServerCert: &confpar.ServerCert{Cert: certFile, Key: keyFile}},
+	})
+
+	tlsConf := server.config.Content.TLS
+	tlsConf.MinVersion = "1.3"
+	tlsConf.CurvePreferences = []string{"X25519"}
+	tlsConf.ClientCA = certFile
+
+	if err := server.reloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := server.GetTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig.MinVersion != tls.VersionTLS13 || len(tlsConfig.CurvePreferences) != 1 ||
+		tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven || tlsConfig.GetCertificate == nil {
+		t.Fatal("Settings not reloaded:", tlsConfig.MinVersion, tlsConfig.CurvePreferences, tlsConfig.ClientAuth)
+	}
+
+	// Invalid settings are refused and the current ones are kept
+	tlsConf.MinVersion = "0.9"
+
+	if err := server.reloadTLS(); err == nil {
+		t.Fatal("Invalid settings should be refused")
+	}
+
+	if current, _ := server.GetTLSConfig(); current != tlsConfig {
+		t.Fatal("The current settings should be kept")
+	}
+}
+
+// fakeClientContext is a client connected from 192.0.2.1
+type fakeClientContext struct {
+	serverlib.ClientContext
+	tls bool
+}
+
+func (cc *fakeClientContext) ID() uint32 {
+	return 1
+}
+
+func (cc *fakeClientContext) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+}
+
+func (cc *fakeClientContext) HasTLSForControl() bool {
+	return cc.tls
+}
+
+func (cc *fakeClientContext) SetTLSRequirement(_ serverlib.TLSRequirement) error {
+	return nil
+}
+
+func TestAuthFailures(t *testing.T) {
+	server, _ := startServer(t, &confpar.Content{
+		BruteForce: &confpar.BruteForce{MaxFailures: 1, BanDuration: time.Minute, Delay: time.Millisecond},
+		Accesses: []*confpar.Access{
+			{User: "user", Pass: "pass", Fs: "os", Params: map[string]string{"basePath": t.TempDir()}},
+		},
+	})
+
+	errBackend := errors.New("database unreachable")
+	static := server.getAuthenticator()
+
+	server.authenticator = auth.NewChain(lognoop.NewNoOpLogger(), &auth.Link{
+		Name: "backend",
+		Authenticator: auth.Func(func(_ serverlib.ClientContext, user, _ string) (*confpar.Access, error) {
+			if user == "backend" {
+				return nil, errBackend
+			}
+
+			return nil, auth.ErrUnknownUser
+		}),
+	}, &auth.Link{Name: "static", Authenticator: static})
+
+	cc := &fakeClientContext{}
+
+	// The client only gets a generic error, and the unavailable backend isn't counted as a guessing attempt
+	if _, err := server.AuthUser(cc, "backend", "pass"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if err := server.guard.Check("192.0.2.1", "user"); err != nil {
+		t.Fatal("The backend error was counted:", err)
+	}
+
+	if _, err := server.AuthUser(cc, "user", "wrong"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if err := server.guard.Check("192.0.2.1", "user"); !errors.Is(err, bruteforce.ErrIPBanned) {
+		t.Fatal("The wrong password wasn't counted:", err)
+	}
+}
+
+func TestLoginCounting(t *testing.T) {
+	server, _ := startServer(t, &confpar.Content{
+		Accesses: []*confpar.Access{{
+			User:        "contractor",
+			Pass:        "pass",
+			Fs:          "os",
+			Params:      map[string]string{"basePath": t.TempDir()},
+			TLSRequired: true,
+			MaxLogins:   1,
+		}},
+	})
+
+	cc := &fakeClientContext{}
+
+	if _, err := server.ClientConnected(cc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refused sessions don't consume the login
+	for range 2 {
+		if _, err := server.AuthUser(cc, "contractor", "pass"); !errors.Is(err, ErrTLSRequired) {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	cc.tls = true
+
+	if _, err := server.AuthUser(cc, "contractor", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := server.AuthUser(cc, "contractor", "pass"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("The login wasn't counted:", err)
+	}
+}
+
+func TestDefaultAuthenticators(t *testing.T) {
+	for expected, content := range map[string]*confpar.Content{
+		"static":      {},
+		"sql":         {SQL: &confpar.SQL{}},
+		"ldap,sql":    {LDAP: &confpar.LDAP{}, SQL: &confpar.SQL{}},
+		"webhook":     {AccessesWebhook: &confpar.AccessesWebhook{}},
+		"webhook,sql": {AccessesWebhook: &confpar.AccessesWebhook{}, SQL: &confpar.SQL{}},
+		"ldap,webhook,sql": {
+			LDAP: &confpar.LDAP{}, AccessesWebhook: &confpar.AccessesWebhook{}, SQL: &confpar.SQL{},
+		},
+	} {
+		var types []string
+		for _, link := range defaultAuthenticators(content) {
+			types = append(types, link.Type)
+		}
+
+		if strings.Join(types, ",") != expected {
+			t.Error("Unexpected chain:", types, "instead of", expected)
+		}
+	}
+}
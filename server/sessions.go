@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	serverlib "github.com/fclairamb/ftpserverlib"
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/ftpserver/fs/fsthrottle"
+	"github.com/fclairamb/ftpserver/metrics"
+)
+
+// session tracks a connected client
+type session struct {
+	cc         serverlib.ClientContext
+	connected  time.Time
+	ctx        context.Context // Done when the client is disconnected
+	cancel     context.CancelFunc
+	user       string // Authenticated user, guarded by Server.nbClientsSync
+	access     string // User of the access, guarded by Server.nbClientsSync
+	fs         string // Backend type, guarded by Server.nbClientsSync
+	downloaded atomic.Int64
+	uploaded   atomic.Int64
+	mu         sync.Mutex
+	transfer   *transfer           // Current transfer
+	clientCert *x509.Certificate   // Verified TLS client certificate
+	throttle   *fsthrottle.Session // Transfer rates, guarded by Server.nbClientsSync
+}
+
+// transfer tracks a file being transferred
+type transfer struct {
+	path      string
+	direction string
+	started   time.Time
+	bytes     atomic.Int64
+}
+
+// SessionInfo describes a connected client
+type SessionInfo struct {
+	ID          uint32        `json:"id"`                     // Client ID
+	User        string        `json:"user,omitempty"`         // Authenticated user
+	RemoteAddr  string        `json:"remote_addr"`            // Client address
+	Fs          string        `json:"fs,omitempty"`           // Backend type
+	ConnectedAt time.Time     `json:"connected_at"`           // Connection time
+	Downloaded  int64         `json:"bytes_downloaded"`       // Bytes downloaded so far
+	Uploaded    int64         `json:"bytes_uploaded"`         // Bytes uploaded so far
+	Transfer    *TransferInfo `json:"transfer,omitempty"`     // Current transfer
+	LastCommand string        `json:"last_command,omitempty"` // Last received command
+}
+
+// TransferInfo describes a file being transferred
+type TransferInfo struct {
+	Path      string    `json:"path"`       // Path of the file
+	Direction string    `json:"direction"`  // upload or download
+	StartedAt time.Time `json:"started_at"` // Start of the transfer
+	Bytes     int64     `json:"bytes"`      // Bytes transferred so far
+}
+
+// Sessions returns the connected clients, sorted by ID
+func (s *Server) Sessions() []*SessionInfo {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	infos := make([]*SessionInfo, 0, len(s.sessions))
+
+	for _, sess := range s.sessions {
+		info := &SessionInfo{
+			ID:          sess.cc.ID(),
+			User:        sess.user,
+			RemoteAddr:  sess.cc.RemoteAddr().String(),
+			Fs:          sess.fs,
+			ConnectedAt: sess.connected,
+			Downloaded:  sess.downloaded.Load(),
+			Uploaded:    sess.uploaded.Load(),
+			LastCommand: sess.cc.GetLastCommand(),
+		}
+
+		sess.mu.Lock()
+		if t := sess.transfer; t != nil {
+			info.Transfer = &TransferInfo{
+				Path:      t.path,
+				Direction: t.direction,
+				StartedAt: t.started,
+				Bytes:     t.bytes.Load(),
+			}
+		}
+		sess.mu.Unlock()
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+// getSession returns the session of a client, or nil if it's not connected
+func (s *Server) getSession(clientID uint32) *session {
+	s.nbClientsSync.Lock()
+	defer s.nbClientsSync.Unlock()
+
+	return s.sessions[clientID]
+}
+
+// KickSession disconnects a client
+func (s *Server) KickSession(clientID uint32) error {
+	sess := s.getSession(clientID)
+	if sess == nil {
+		return ErrUnknownSession
+	}
+
+	s.logger.Info("Kicking client", "clientId", clientID, "remoteAddr", sess.cc.RemoteAddr())
+
+	return sess.cc.Close()
+}
+
+// sessionFs tracks the transfers of a session
+type sessionFs struct {
+	afero.Fs
+	session *session
+}
+
+func (f *sessionFs) wrap(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionFile{File: file, session: f.session}, nil
+}
+
+// Create returns a tracked file
+func (f *sessionFs) Create(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Create(name))
+}
+
+// Open returns a tracked file
+func (f *sessionFs) Open(name string) (afero.File, error) {
+	return f.wrap(f.Fs.Open(name))
+}
+
+// OpenFile returns a tracked file
+func (f *sessionFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return f.wrap(f.Fs.OpenFile(name, flag, perm))
+}
+
+// sessionFile tracks a file transfer, which starts at the first read or write
+type sessionFile struct {
+	afero.File
+	session  *session
+	transfer *transfer
+}
+
+func (f *sessionFile) count(direction string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	if f.transfer == nil {
+		f.transfer = &transfer{
+			path:      f.File.Name(),
+			direction: direction,
+			started:   time.Now(),
+		}
+
+		f.session.mu.Lock()
+		f.session.transfer = f.transfer
+		f.session.mu.Unlock()
+	}
+
+	f.transfer.bytes.Add(int64(n))
+
+	if direction == metrics.Upload {
+		f.session.uploaded.Add(int64(n))
+	} else {
+		f.session.downloaded.Add(int64(n))
+	}
+}
+
+// Read counts the downloaded bytes
+func (f *sessionFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.count(metrics.Download, n)
+
+	return n, err
+}
+
+// ReadAt counts the downloaded bytes
+func (f *sessionFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.count(metrics.Download, n)
+
+	return n, err
+}
+
+// Write counts the uploaded bytes
+func (f *sessionFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.count(metrics.Upload, n)
+
+	return n, err
+}
+
+// WriteAt counts the uploaded bytes
+func (f *sessionFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.count(metrics.Upload, n)
+
+	return n, err
+}
+
+// WriteString counts the uploaded bytes
+func (f *sessionFile) WriteString(str string) (int, error) {
+	n, err := f.File.WriteString(str)
+	f.count(metrics.Upload, n)
+
+	return n, err
+}
+
+// TransferError forwards the transfer errors
+func (f *sessionFile) TransferError(err error) {
+	if src, ok := f.File.(serverlib.FileTransferError); ok {
+		src.TransferError(err)
+	}
+}
+
+// Close ends the transfer
+func (f *sessionFile) Close() error {
+	if f.transfer != nil {
+		f.session.mu.Lock()
+		if f.session.transfer == f.transfer {
+			f.session.transfer = nil
+		}
+		f.session.mu.Unlock()
+	}
+
+	return f.File.Close()
+}